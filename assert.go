@@ -0,0 +1,84 @@
+package clac
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAssertion is wrapped by the errors of failed assertions.
+var ErrAssertion = errors.New("assertion failed")
+
+// Assertion is the result of an assertion command.
+type Assertion struct {
+	Cmd  string // assertion command
+	Msg  string // message following the command, if any
+	Line int    // line of the command in the input
+	Col  int    // column of the command in its line
+	Err  error  // nil if the assertion passed
+}
+
+// OnAssert sets f to be called with the result of each assertion.  While f
+// is set, failed assertions are reported to f instead of failing.  f is
+// called while the assertion runs, so it must not call methods of c.
+// OnAssert must not be called from inside a command, such as the Func of a
+// registered Cmd, as it would deadlock.
+func (c *Clac) OnAssert(f func(Assertion)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAssert = f
+}
+
+// Assert pops x, and fails if it is 0.
+func (c *Clac) Assert() error {
+	x, err := c.Pop()
+	if err != nil {
+		return err
+	}
+	return c.assert("assert", isTrue(x), "got "+c.Sprint(x))
+}
+
+// Expect pops y and x, and fails unless y is equal to x.
+func (c *Clac) Expect() error {
+	vals, err := c.remove(0, 2)
+	if err != nil {
+		return err
+	}
+	eq, err := c.binary(vals[1], "==", vals[0])
+	if err != nil {
+		return err
+	}
+	return c.assert("expect", isTrue(eq), fmt.Sprintf("got %s, want %s", c.Sprint(vals[1]), c.Sprint(vals[0])))
+}
+
+// ExpectNear pops z, y, and x, and fails unless z and y differ by at most x.
+func (c *Clac) ExpectNear() error {
+	vals, err := c.remove(0, 3)
+	if err != nil {
+		return err
+	}
+	e := c.newEval()
+	diff := e.unary("abs", e.binary(vals[2], "-", vals[1]))
+	near := e.binary(diff, "<=", vals[0])
+	if e.err != nil {
+		return e.err
+	}
+	detail := fmt.Sprintf("got %s, want %s within %s", c.Sprint(vals[2]), c.Sprint(vals[1]), c.Sprint(vals[0]))
+	return c.assert("expect~", isTrue(near), detail)
+}
+
+// assert reports the result of an assertion, and returns an error if it
+// failed and results aren't reported via OnAssert.
+func (c *Clac) assert(cmd string, ok bool, detail string) error {
+	var err error
+	if !ok {
+		if c.msg != "" {
+			detail = c.msg + ": " + detail
+		}
+		err = fmt.Errorf("%w: %s", ErrAssertion, detail)
+	}
+	if c.onAssert == nil {
+		return err
+	}
+	c.onAssert(Assertion{Cmd: cmd, Msg: c.msg, Line: c.msgTok.line, Col: c.msgTok.col, Err: err})
+	return nil
+}
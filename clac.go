@@ -2,7 +2,13 @@
 package clac
 
 import (
+	"context"
 	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
 
 	"robpike.io/ivy/config"
 	"robpike.io/ivy/exec"
@@ -13,127 +19,354 @@ var (
 	zero       value.Value = value.Int(0)
 	E, Pi, Phi value.Value
 
-	ErrTooFewArgs    = errors.New("too few arguments")
-	ErrInvalidArg    = errors.New("invalid argument")
-	ErrNoMoreChanges = errors.New("no more changes")
-	ErrNoHistUpdate  = errors.New("") // for cmds that don't add to history
-
-	ivyCfg = &config.Config{}
-	ivyCtx = exec.NewContext(ivyCfg)
+	ErrTooFewArgs     = errors.New("too few arguments")
+	ErrInvalidArg     = errors.New("invalid argument")
+	ErrInvalidInput   = errors.New("invalid input")
+	ErrMissingArg     = errors.New("missing argument")
+	ErrNoMoreChanges  = errors.New("no more changes")
+	ErrInvalidSession = errors.New("invalid session")
+	ErrTooLarge       = errors.New("result too large")
+	ErrTooDeep        = errors.New("nesting too deep")
+	ErrNameInUse      = errors.New("name in use")
+	ErrNoHistUpdate   = errors.New("") // for cmds that don't add to history
+
+	// the default config is used by the package level functions
+	defaultCfg = &config.Config{}
+	defaultCtx = exec.NewContext(defaultCfg)
 )
 
 func init() {
-	E, Pi = value.Consts(ivyCtx)
-	e := &eval{}
-	Phi = e.binary(e.binary(value.Int(1), "+", e.unary("sqrt", value.Int(5))), "/", value.Int(2))
+	E, Pi = value.Consts(defaultCtx)
+	Phi = phi(defaultCtx)
+}
+
+// phi returns the golden ratio.
+func phi(ctx value.Context) value.Value {
+	e := &eval{ctx: ctx}
+	return e.binary(e.binary(value.Int(1), "+", e.unary("sqrt", value.Int(5))), "/", value.Int(2))
 }
 
-// Sprint returns a stringified value
+// Sprint returns a stringified value using the default config.
 func Sprint(val value.Value) string {
-	return val.Sprint(ivyCfg)
+	return val.Sprint(defaultCfg)
 }
 
-// SetFormat sets the ivy output format
+// SetFormat sets the default ivy output format.  It does not affect Clac
+// instances, which each have their own config.
 func SetFormat(format string) {
-	ivyCfg.SetFormat(format)
+	defaultCfg.SetFormat(format)
 }
 
-// ParseNum wraps value.Parse() to handle panics on unexpected input
-func ParseNum(tok string) (val value.Value, err error) {
+// ParseNum parses a number using the default config.
+func ParseNum(tok string) (value.Value, error) {
+	return parseNum(defaultCfg, tok)
+}
+
+// parseNum wraps value.Parse() to handle panics on unexpected input
+func parseNum(cfg *config.Config, tok string) (val value.Value, err error) {
 	defer func() {
 		if recover() != nil {
 			err = ErrInvalidArg
 		}
 	}()
-	return value.Parse(ivyCfg, tok)
+	return value.Parse(cfg, tok)
 }
 
 // Stack represents a stack of floating point numbers.
 type Stack []value.Value
 
-type stackHist struct {
-	cur  int
-	hist []Stack
-}
+// Clac represents an RPN calculator.
+//
+// Clac is safe for concurrent use.  Commands run through Exec are serialized,
+// and Stack and History return snapshots of the state after the last
+// completed command, so they may be called while a command is running.  The
+// command methods (Push, Pop, Add, etc.) operate directly on the working stack
+// and must only be called via Exec when a Clac is shared between goroutines.
+type Clac struct {
+	mu       sync.Mutex // serializes commands
+	working  Stack
+	vars     Vars  // working variables, copied before modification
+	lastX    Stack // values removed by the last command that removed any
+	removed  Stack // values removed by the running command
+	keepHist bool
+	hist     *stackHist
+	maxSteps int
+	maxBytes int
+	maxBits  int            // size limit for integers, or 0
+	maxTime  time.Duration  // time limit for commands, or 0
+	cfg      *config.Config // modified only while holding both mu and cfgMu
+	ctx      value.Context
+	runCtx   context.Context // context of the running command
+	nest     int             // depth of nested atomic runs
+	msg      string          // message given to the running command
+	msgTok   token           // token of the running command
+	onAssert func(Assertion)
+	onTrace  func(Trace)
+	depth    int // depth of nested runs of input, for tracing
 
-func newStackHist() *stackHist {
-	return &stackHist{hist: []Stack{Stack{}}}
-}
+	e, pi, phi value.Value // constants at the instance's float precision
 
-func (s *stackHist) undo() bool {
-	if s.cur <= 0 {
-		return false
-	}
-	s.cur--
-	return true
-}
+	evKind EventKind // kind of event for the current command
+	events []Event   // events to deliver when mu is unlocked
+	seq    uint64
 
-func (s *stackHist) redo() bool {
-	if s.cur >= len(s.hist)-1 {
-		return false
-	}
-	s.cur++
-	return true
-}
+	cmdMu sync.RWMutex // guards cmds and words
+	cmds  map[string]Cmd
+	words map[string]string // bodies of user defined words, by name
 
-func (s *stackHist) push(stack Stack) {
-	s.hist = append(s.hist[:s.cur+1], stack)
-	s.cur++
-}
+	subMu   sync.Mutex // guards subs
+	subs    map[int]func(Event)
+	nextSub int
 
-func (s *stackHist) replace(stack Stack) {
-	s.hist[s.cur] = stack
-}
+	snapMu   sync.RWMutex // guards the snapshot fields
+	snapHist []histState
+	snapCur  int
 
-func (s *stackHist) stack() Stack {
-	return s.hist[s.cur]
-}
+	fmtMu  sync.Mutex // guards fmtCfg
+	fmtCfg *config.Config
 
-// Clac represents an RPN calculator.
-type Clac struct {
-	working  Stack
-	keepHist bool
-	hist     *stackHist
+	cfgMu sync.Mutex // guards cfg for ParseNum, which may be called without mu
 }
 
 // New returns an initialized Clac instance.
 func New() *Clac {
-	c := &Clac{keepHist: true}
+	cfg := &config.Config{}
+	c := &Clac{
+		keepHist: true,
+		cfg:      cfg,
+		ctx:      exec.NewContext(cfg),
+		cmds:     map[string]Cmd{},
+		words:    map[string]string{},
+		subs:     map[int]func(Event){},
+		fmtCfg:   &config.Config{},
+	}
+	c.setConsts()
 	c.Reset()
+	c.publish()
 	return c
 }
 
+// Sprint returns a stringified value using the instance's output format.
+func (c *Clac) Sprint(val value.Value) string {
+	c.fmtMu.Lock()
+	defer c.fmtMu.Unlock()
+	return val.Sprint(c.fmtCfg)
+}
+
+// SetFormat sets the instance's ivy output format.
+func (c *Clac) SetFormat(format string) {
+	c.fmtMu.Lock()
+	defer c.fmtMu.Unlock()
+	c.fmtCfg.SetFormat(format)
+}
+
+// ParseNum parses a number using the instance's config.
+func (c *Clac) ParseNum(tok string) (value.Value, error) {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	return parseNum(c.cfg, tok)
+}
+
 // EnableHistory sets whether to retain history
 func (c *Clac) EnableHistory(enable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.keepHist = enable
 	if !enable {
 		c.hist = newStackHist()
+		c.hist.replace(c.working, c.vars, "")
+		c.updateWorking()
+		c.publish()
+	}
+}
+
+// SetHistoryLimits limits the undo history to at most maxSteps undo steps,
+// and approximately maxBytes of stack values.  States that can't be reached
+// by undo or redo from the current state are discarded first, then the
+// oldest undo steps, then the furthest redo steps.  A limit of 0 means no
+// limit.
+func (c *Clac) SetHistoryLimits(maxSteps, maxBytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSteps, c.maxBytes = maxSteps, maxBytes
+	c.hist.trim(c.maxSteps, c.maxBytes)
+	c.publish()
+}
+
+// SetLimits limits the results of commands to integers of at most maxDigits
+// decimal digits, and limits commands to running for at most maxTime.  A
+// command exceeding a limit fails with ErrTooLarge or
+// context.DeadlineExceeded.  A limit of 0 or less means no limit.
+func (c *Clac) SetLimits(maxDigits int, maxTime time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBits = 0
+	if maxDigits > 0 {
+		c.maxBits = int(math.Ceil(float64(maxDigits) * math.Log2(10)))
+	}
+	c.maxTime = maxTime
+	c.cfgMu.Lock()
+	c.cfg.SetMaxBits(uint(c.maxBits))
+	c.cfgMu.Unlock()
+}
+
+// SetFloatPrec sets the precision in bits of floating point calculations,
+// which must be positive.  Values already on the stack keep their precision.
+func (c *Clac) SetFloatPrec(bits uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setFloatPrec(bits)
+}
+
+// FloatPrec returns the precision in bits of floating point calculations.
+func (c *Clac) FloatPrec() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg.FloatPrec()
+}
+
+// Prec sets the precision in bits of floating point calculations to the
+// number given by arg.
+func (c *Clac) Prec(arg string) error {
+	bits, err := strconv.ParseUint(arg, 0, 32)
+	if err != nil || bits == 0 || bits > big.MaxPrec {
+		return ErrInvalidArg
 	}
+	c.setFloatPrec(uint(bits))
+	return ErrNoHistUpdate
+}
+
+func (c *Clac) setFloatPrec(bits uint) {
+	c.cfgMu.Lock()
+	c.cfg.SetFloatPrec(bits)
+	c.cfgMu.Unlock()
+	c.setConsts()
+}
+
+// setConsts computes the constants at the instance's float precision.
+func (c *Clac) setConsts() {
+	c.e, c.pi = value.Consts(c.ctx)
+	c.phi = phi(c.ctx)
 }
 
 // Reset resets clac to its initial state
 func (c *Clac) Reset() error {
 	c.working = Stack{}
+	c.vars = nil
+	c.lastX = nil
 	c.hist = newStackHist()
+	c.evKind = EventReset
 	return ErrNoHistUpdate
 }
 
-// Stack returns the current stack.
+// Stack returns a copy of the stack as of the last completed command.
 func (c *Clac) Stack() Stack {
-	return c.working
+	c.snapMu.RLock()
+	defer c.snapMu.RUnlock()
+	return c.snapHist[c.snapCur].top.toStack()
+}
+
+// History returns a copy of the undo history as of the last completed
+// command, in order of creation, along with the id of the current state.
+func (c *Clac) History() ([]HistEntry, int) {
+	c.snapMu.RLock()
+	defer c.snapMu.RUnlock()
+	return statesToEntries(c.snapHist), c.snapHist[c.snapCur].id
+}
+
+// HistoryPath returns the states of the undo history leading to the current
+// state as of the last completed command, oldest first.
+func (c *Clac) HistoryPath() []HistEntry {
+	c.snapMu.RLock()
+	defer c.snapMu.RUnlock()
+	var path []histState
+	for i, ok := c.snapCur, true; ok; i, ok = stateIndex(c.snapHist, c.snapHist[i].parent) {
+		path = append(path, c.snapHist[i])
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return statesToEntries(path)
+}
+
+// Branches returns the ids of the states at the tips of the branches of the
+// undo history as of the last completed command, in order of the creation of
+// the branches.
+func (c *Clac) Branches() []int {
+	c.snapMu.RLock()
+	defer c.snapMu.RUnlock()
+	return tips(c.snapHist)
+}
+
+// JumpTo makes the state in the undo history with the given id current, as
+// for the goto command.
+func (c *Clac) JumpTo(id int) error {
+	return c.ExecCmd("goto", func() error { return c.jump(id) })
 }
 
-// Exec executes a clac command, along with necessary bookkeeping
+// publish updates the snapshots returned by Stack and History.
+func (c *Clac) publish() {
+	c.snapMu.Lock()
+	defer c.snapMu.Unlock()
+	c.snapHist = c.hist.states
+	c.snapCur = c.hist.cur
+}
+
+// Exec executes a clac command, along with necessary bookkeeping.  Commands
+// are serialized, so Exec may be called from multiple goroutines.
 func (c *Clac) Exec(f func() error) error {
-	err := f()
+	return c.ExecCmdContext(context.Background(), "", f)
+}
+
+// ExecContext is like Exec, but the command fails with the context's error
+// if the context is done before it completes.  Cancellation is checked
+// between arithmetic operations.
+func (c *Clac) ExecContext(ctx context.Context, f func() error) error {
+	return c.ExecCmdContext(ctx, "", f)
+}
+
+// ExecCmd is like Exec, but records name as the command that produced the
+// resulting state in the undo history.
+func (c *Clac) ExecCmd(name string, f func() error) error {
+	return c.ExecCmdContext(context.Background(), name, f)
+}
+
+// ExecCmdContext is like ExecCmd, but with cancellation as for ExecContext.
+func (c *Clac) ExecCmdContext(ctx context.Context, name string, f func() error) error {
+	c.mu.Lock()
+	defer c.unlock()
+	return c.exec(ctx, name, f)
+}
+
+func (c *Clac) exec(ctx context.Context, name string, f func() error) error {
+	if c.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.maxTime)
+		defer cancel()
+	}
+	c.runCtx = ctx
+	defer func() { c.runCtx = nil }()
+	before := c.hist.states[c.hist.cur]
+	c.evKind = EventExec
+	c.removed = nil
+	err := ctx.Err()
 	if err == nil {
+		err = f()
+	}
+	if err == nil {
+		c.keepRemoved()
 		if c.keepHist {
-			c.hist.push(c.working)
+			c.hist.push(c.working, c.vars, name)
+			c.hist.trim(c.maxSteps, c.maxBytes)
 		} else {
-			c.hist.replace(c.working)
+			c.hist.replace(c.working, c.vars, name)
 		}
 	}
 	c.updateWorking()
+	c.publish()
+	if err == nil || err == ErrNoHistUpdate {
+		c.addEvent(name, before)
+	}
 	if err == ErrNoHistUpdate {
 		return nil
 	}
@@ -141,7 +374,8 @@ func (c *Clac) Exec(f func() error) error {
 }
 
 func (c *Clac) updateWorking() {
-	c.working = append(Stack{}, c.hist.stack()...)
+	c.working = c.hist.stack()
+	c.vars = c.hist.vars()
 }
 
 func (c *Clac) checkRange(pos, num int, isEndOK bool) (int, int, error) {
@@ -173,7 +407,16 @@ func (c *Clac) Push(x value.Value) error {
 	return c.insert([]value.Value{x}, 0)
 }
 
+// remove removes num values starting at pos, and records them as removed by
+// the running command.
 func (c *Clac) remove(pos, num int) ([]value.Value, error) {
+	vals, err := c.cut(pos, num)
+	c.removed = append(c.removed, vals...)
+	return vals, err
+}
+
+// cut removes num values starting at pos, for reinsertion elsewhere.
+func (c *Clac) cut(pos, num int) ([]value.Value, error) {
 	start, end, err := c.checkRange(pos, num, false)
 	if err != nil {
 		return nil, err
@@ -183,6 +426,15 @@ func (c *Clac) remove(pos, num int) ([]value.Value, error) {
 	return vals, nil
 }
 
+// keepRemoved saves the values removed by a command that completed, if it
+// removed any, for LastX.
+func (c *Clac) keepRemoved() {
+	if len(c.removed) > 0 {
+		c.lastX = c.removed
+	}
+	c.removed = nil
+}
+
 // Pop pops a value off the stack.
 func (c *Clac) Pop() (value.Value, error) {
 	x, err := c.remove(0, 1)
@@ -194,7 +446,15 @@ func (c *Clac) Pop() (value.Value, error) {
 
 // Trunc returns the given value rounded to the nearest integer toward 0
 func Trunc(val value.Value) (value.Value, error) {
-	e := &eval{}
+	return trunc(defaultCtx, val)
+}
+
+func (c *Clac) trunc(val value.Value) (value.Value, error) {
+	return trunc(c.ctx, val)
+}
+
+func trunc(ctx value.Context, val value.Value) (value.Value, error) {
+	e := &eval{ctx: ctx}
 	if isTrue(e.binary(val, ">=", zero)) {
 		val = e.unary("floor", val)
 	} else {
@@ -208,7 +468,7 @@ func (c *Clac) popIntMin(min int) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	n, err := valToInt(val)
+	n, err := c.valToInt(val)
 	if err != nil {
 		return 0, err
 	}
@@ -218,8 +478,8 @@ func (c *Clac) popIntMin(min int) (int, error) {
 	return n, nil
 }
 
-func valToInt(val value.Value) (int, error) {
-	val, err := Trunc(val)
+func (c *Clac) valToInt(val value.Value) (int, error) {
+	val, err := c.trunc(val)
 	if err != nil {
 		return 0, err
 	}
@@ -264,25 +524,90 @@ func (c *Clac) rotate(pos, num int, isDown bool) error {
 	if isDown {
 		from, to = to, from
 	}
-	vals, err := c.remove(from, num)
+	vals, err := c.cut(from, num)
 	if err != nil {
 		return err
 	}
 	return c.insert(vals, to)
 }
 
-func unary(op string, a value.Value) (val value.Value, err error) {
+func (c *Clac) unary(op string, a value.Value) (value.Value, error) {
+	if err := c.interrupted(); err != nil {
+		return zero, err
+	}
+	val, err := unary(c.ctx, op, a)
+	if err == nil {
+		err = c.checkSize(val)
+	}
+	return val, err
+}
+
+func (c *Clac) binary(a value.Value, op string, b value.Value) (value.Value, error) {
+	if err := c.interrupted(); err != nil {
+		return zero, err
+	}
+	val, err := binary(c.ctx, a, op, b)
+	if err == nil {
+		err = c.checkSize(val)
+	}
+	return val, err
+}
+
+// interrupted returns the error of the running command's context, if it is
+// done.
+func (c *Clac) interrupted() error {
+	if c.runCtx == nil {
+		return nil
+	}
+	return c.runCtx.Err()
+}
+
+// checkSize returns ErrTooLarge if val exceeds the size limit.
+func (c *Clac) checkSize(val value.Value) error {
+	if c.maxBits == 0 {
+		return nil
+	}
+	bits := 0
+	switch v := val.(type) {
+	case value.BigInt:
+		bits = v.Int.BitLen()
+	case value.BigRat:
+		bits = v.Rat.Num().BitLen()
+		if d := v.Rat.Denom().BitLen(); d > bits {
+			bits = d
+		}
+	case value.Complex:
+		e := &eval{ctx: c.ctx}
+		re, im := e.parts(v)
+		if err := c.checkSize(re); err != nil {
+			return err
+		}
+		return c.checkSize(im)
+	}
+	if bits > c.maxBits {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+func unary(ctx value.Context, op string, a value.Value) (val value.Value, err error) {
 	defer func() { err = errVal(recover()) }()
-	val = ivyCtx.EvalUnary(op, a)
+	val = ctx.EvalUnary(op, a)
 	return val, err
 }
 
-func binary(a value.Value, op string, b value.Value) (val value.Value, err error) {
+func binary(ctx value.Context, a value.Value, op string, b value.Value) (val value.Value, err error) {
 	defer func() { err = errVal(recover()) }()
-	val = ivyCtx.EvalBinary(a, op, b)
+	val = ctx.EvalBinary(a, op, b)
 	return val, err
 }
 
+// canonical returns val reduced by ivy to its canonical type, such as an Int
+// for a small BigInt, or a BigInt for a BigRat with a denominator of 1.
+func canonical(ctx value.Context, val value.Value) (value.Value, error) {
+	return binary(ctx, val, "+", zero)
+}
+
 func errVal(val interface{}) error {
 	if val == nil {
 		return nil
@@ -294,9 +619,23 @@ func errVal(val interface{}) error {
 }
 
 type eval struct {
+	ctx value.Context
+	c   *Clac // if not nil, evaluates via c to apply its limits
 	err error
 }
 
+func (c *Clac) newEval() *eval {
+	return &eval{ctx: c.ctx, c: c}
+}
+
+// pi returns pi at the precision of e's Clac, if it has one.
+func (e *eval) pi() value.Value {
+	if e.c != nil {
+		return e.c.pi
+	}
+	return Pi
+}
+
 func (e *eval) e(f func() (value.Value, error)) value.Value {
 	if e.err != nil {
 		return zero
@@ -307,9 +646,15 @@ func (e *eval) e(f func() (value.Value, error)) value.Value {
 }
 
 func (e *eval) unary(op string, a value.Value) value.Value {
-	return e.e(func() (value.Value, error) { return unary(op, a) })
+	if e.c != nil {
+		return e.e(func() (value.Value, error) { return e.c.unary(op, a) })
+	}
+	return e.e(func() (value.Value, error) { return unary(e.ctx, op, a) })
 }
 
 func (e *eval) binary(a value.Value, op string, b value.Value) value.Value {
-	return e.e(func() (value.Value, error) { return binary(a, op, b) })
+	if e.c != nil {
+		return e.e(func() (value.Value, error) { return e.c.binary(a, op, b) })
+	}
+	return e.e(func() (value.Value, error) { return binary(e.ctx, a, op, b) })
 }
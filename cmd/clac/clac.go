@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,8 +10,11 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ianremmler/clac"
 	"golang.org/x/crypto/ssh/terminal"
@@ -24,6 +27,7 @@ const (
 	cliMode runMode = iota
 	tuiMode
 	dmenuMode
+	testMode
 )
 
 var (
@@ -31,103 +35,28 @@ var (
 	doDmenu          = false
 	doInitStack      = false
 	doHexOut         = false
+	doAtomic         = false
+	doTrace          = false
+	ratMode          = "float"
+	scriptFile       = ""
 	outPrec     uint = 12
+	floatPrec   uint = 256
+	maxUndo          = 0
+	maxDigits        = 0
+	maxTime          = time.Duration(0)
 
 	cl      = clac.New()
 	lastErr error
-)
+	tuiList []string // shown in place of the stack on the next redraw
 
-var cmdMap = map[string]func() error{
-	"neg":    cl.Neg,
-	"n":      cl.Neg,
-	"abs":    cl.Abs,
-	"a":      cl.Abs,
-	"inv":    cl.Inv,
-	"i":      cl.Inv,
-	"+":      cl.Add,
-	"-":      cl.Sub,
-	"*":      cl.Mul,
-	"x":      cl.Mul,
-	"/":      cl.Div,
-	"div":    cl.IntDiv,
-	"%":      cl.Mod,
-	"exp":    cl.Exp,
-	"^":      cl.Pow,
-	"2^":     cl.Pow2,
-	"10^":    cl.Pow10,
-	"logn":   cl.LogN,
-	"ln":     cl.Ln,
-	"log":    cl.Log,
-	"lg":     cl.Lg,
-	"sqrt":   cl.Sqrt,
-	"!":      cl.Factorial,
-	"comb":   cl.Comb,
-	"perm":   cl.Perm,
-	"sin":    cl.Sin,
-	"cos":    cl.Cos,
-	"tan":    cl.Tan,
-	"asin":   cl.Asin,
-	"acos":   cl.Acos,
-	"atan":   cl.Atan,
-	"atan2":  cl.Atan2,
-	"dtor":   cl.DegToRad,
-	"rtod":   cl.RadToDeg,
-	"rtop":   cl.RectToPolar,
-	"ptor":   cl.PolarToRect,
-	"floor":  cl.Floor,
-	"ceil":   cl.Ceil,
-	"trunc":  cl.Trunc,
-	"and":    cl.And,
-	"or":     cl.Or,
-	"xor":    cl.Xor,
-	"not":    cl.Not,
-	"andn":   cl.AndN,
-	"orn":    cl.OrN,
-	"xorn":   cl.XorN,
-	"sum":    cl.Sum,
-	"avg":    cl.Avg,
-	"drop":   cl.Drop,
-	"k":      cl.Drop,
-	"dropn":  cl.DropN,
-	"dropr":  cl.DropR,
-	"dup":    cl.Dup,
-	"d":      cl.Dup,
-	"dupn":   cl.DupN,
-	"dupr":   cl.DupR,
-	"pick":   cl.Pick,
-	"p":      cl.Pick,
-	"swap":   cl.Swap,
-	"s":      cl.Swap,
-	"depth":  cl.Depth,
-	"min":    cl.Min,
-	"max":    cl.Max,
-	"minn":   cl.MinN,
-	"maxn":   cl.MaxN,
-	"rot":    cl.Rot,
-	"rotr":   cl.RotR,
-	"unrot":  cl.Unrot,
-	"unrotr": cl.UnrotR,
-	"mag":    cl.Mag,
-	"hyp":    cl.Hypot,
-	"dot":    cl.Dot,
-	"dot3":   cl.Dot3,
-	"cross":  cl.Cross,
-	"pi":     constant(clac.Pi),
-	"e":      constant(clac.E),
-	"phi":    constant(clac.Phi),
-}
-
-var uiCmdMap = map[string]func() error{
-	"undo":  cl.Undo,
-	"u":     cl.Undo,
-	"redo":  cl.Redo,
-	"r":     cl.Redo,
-	"clear": cl.Clear,
-	"c":     cl.Clear,
-	"reset": cl.Reset,
-	"quit":  quit,
-	"q":     quit,
-}
+	traceToList = false // list traces in the TUI rather than printing them
+
+	// step mode
+	stepWait  = false  // the next input line is the program to step through
+	stepItems []string // items of the program, or nil if not stepping
+	stepPos   = 0      // index of the next item to execute
+	stepIDs   []int    // ids of the states before each executed item
+)
 
 type term struct {
 	io.Reader
@@ -139,12 +68,23 @@ func init() {
 	log.SetPrefix("clac: ")
 	flag.BoolVar(&doDmenu, "d", doDmenu, "dmenu mode")
 	flag.BoolVar(&doHexOut, "x", doHexOut, "hexidecimal output")
+	flag.StringVar(&ratMode, "r", ratMode, "rational display mode: float, frac, or mixed")
 	flag.UintVar(&outPrec, "p", outPrec, "output precision")
+	flag.UintVar(&floatPrec, "b", floatPrec, "float precision in bits")
 	flag.BoolVar(&doInitStack, "i", doInitStack, "initialize stack")
+	flag.StringVar(&scriptFile, "f", scriptFile, "run script file, with arguments bound to $1, $2, etc.")
+	flag.BoolVar(&doAtomic, "a", doAtomic, "undo each input line as a single step")
+	flag.BoolVar(&doTrace, "trace", doTrace, "print each item of input with the resulting stack")
+	flag.IntVar(&maxUndo, "u", maxUndo, "maximum undo steps (0 for unlimited)")
+	flag.IntVar(&maxDigits, "m", maxDigits, "maximum digits in integer results (0 for unlimited)")
+	flag.DurationVar(&maxTime, "t", maxTime, "maximum time per command (0 for unlimited)")
 }
 
 func main() {
 	flag.Parse()
+	if !isRatMode(ratMode) {
+		log.Fatalf("invalid rational display mode: %s", ratMode)
+	}
 	var mode runMode
 	mode, lastErr = processCmdLine()
 	switch mode {
@@ -154,13 +94,11 @@ func main() {
 		tuiRun()
 	case dmenuMode:
 		dmenuRun()
+	case testMode:
+		testRun(flag.Args()[1:])
 	}
 }
 
-func constant(v value.Value) func() error {
-	return func() error { return cl.Push(v) }
-}
-
 func cliRun() {
 	fmt.Println(stackStr(cl.Stack()))
 	if lastErr != nil {
@@ -169,14 +107,118 @@ func cliRun() {
 }
 
 func uiSetup() {
-	for cmd, fn := range uiCmdMap {
-		cmdMap[cmd] = fn
+	cl.Register(clac.Cmd{
+		Name:     "quit",
+		Aliases:  []string{"q"},
+		Category: "session",
+		Help:     "quit clac",
+		Func:     func(*clac.Clac) error { return quit() },
+	})
+	cl.Register(clac.Cmd{
+		Name:     "ratmode",
+		Category: "session",
+		Help:     "display rationals as float, frac, or mixed",
+		ArgFunc:  setRatMode,
+	})
+}
+
+func isRatMode(mode string) bool {
+	return mode == "float" || mode == "frac" || mode == "mixed"
+}
+
+func setRatMode(c *clac.Clac, mode string) error {
+	if !isRatMode(mode) {
+		return clac.ErrInvalidArg
 	}
-	uiCmdMap = nil
+	ratMode = mode
+	return clac.ErrNoHistUpdate
 }
 
-func tuiRun() {
+// fracStr returns val as a fraction, if rationals are displayed as fractions
+// and val is rational.
+func fracStr(val value.Value) (string, bool) {
+	if ratMode == "float" {
+		return "", false
+	}
+	return clac.SprintFrac(val, ratMode == "mixed")
+}
+
+func tuiSetup() {
 	uiSetup()
+	cl.Register(clac.Cmd{
+		Name:     "branches",
+		Category: "session",
+		Help:     "list the undo history branches",
+		Func:     tuiBranches,
+	})
+	cl.Register(clac.Cmd{
+		Name:     "hist",
+		Category: "session",
+		Help:     "list the commands leading to the current state",
+		Func:     tuiHist,
+	})
+	cl.Register(clac.Cmd{
+		Name:     "cmds",
+		Category: "session",
+		Help:     "list the commands by category",
+		Func:     tuiCmds,
+	})
+	cl.Register(clac.Cmd{
+		Name:     "help",
+		Category: "session",
+		Help:     "describe the named command",
+		ArgFunc:  tuiHelp,
+	})
+	cl.Register(clac.Cmd{
+		Name:     "vars",
+		Category: "session",
+		Help:     "list the variables",
+		Func:     tuiVars,
+	})
+	cl.Register(clac.Cmd{
+		Name:     "atomic",
+		Category: "session",
+		Help:     "toggle undoing each input line as a single step",
+		Func:     func(*clac.Clac) error { doAtomic = !doAtomic; return clac.ErrNoHistUpdate },
+	})
+	cl.Register(clac.Cmd{
+		Name:     "trace",
+		Category: "session",
+		Help:     "toggle listing each item of input with the resulting stack",
+		Func:     func(*clac.Clac) error { doTrace = !doTrace; return clac.ErrNoHistUpdate },
+	})
+	cl.Register(clac.Cmd{
+		Name:     "step",
+		Category: "session",
+		Help:     "step through the program on the next line; enter runs an item, back undoes one",
+		Func:     func(*clac.Clac) error { stepWait = true; return clac.ErrNoHistUpdate },
+	})
+}
+
+// traceSetup installs the trace handler, which, while doTrace is set, lists
+// each item of input with the resulting stack in the TUI, or prints it to
+// stderr otherwise.  It is installed up front, as the trace command can't call
+// OnTrace, so the command only toggles doTrace.
+func traceSetup() {
+	cl.OnTrace(func(t clac.Trace) {
+		if !doTrace {
+			return
+		}
+		line := fmt.Sprintf("%s%-20s %s", strings.Repeat("  ", t.Depth), t.Item, stackStr(t.Stack))
+		line = strings.TrimRight(line, " ")
+		if t.Err != nil {
+			line += "  [ " + t.Err.Error() + " ]"
+		}
+		if traceToList {
+			tuiList = append(tuiList, line)
+		} else {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	})
+}
+
+func tuiRun() {
+	tuiSetup()
 	if !terminal.IsTerminal(syscall.Stdin) {
 		log.Fatalln("this doesn't look like an interactive terminal")
 	}
@@ -185,22 +227,100 @@ func tuiRun() {
 		log.Fatalln(err)
 	}
 	trm := terminal.NewTerminal(term{os.Stdin, os.Stdout}, "")
+	trm.AutoCompleteCallback = tuiComplete
 	for lastErr != io.EOF {
 		tuiPrintStack(cl.Stack())
 		var input string
 		input, lastErr = trm.ReadLine()
 		if lastErr == nil {
-			lastErr = processInput(input)
+			lastErr = tuiStep(input, oldTrmState)
 		}
 	}
 	terminal.Restore(syscall.Stdin, oldTrmState)
 }
 
+// tuiStep processes input in step mode, in which enter executes the next
+// item of the program and back returns to the state before the last one.
+// Other input leaves step mode and is processed normally.
+func tuiStep(input string, trmState *terminal.State) error {
+	switch {
+	case stepWait:
+		stepWait = false
+		stepItems, stepPos, stepIDs = cl.Split(input), 0, nil
+		return nil
+	case stepItems == nil:
+	case strings.TrimSpace(input) == "":
+		if stepPos == len(stepItems) {
+			stepItems = nil
+			return nil
+		}
+		_, id := cl.History()
+		if err := tuiProcessInput(stepItems[stepPos], trmState); err != nil {
+			return err
+		}
+		stepIDs = append(stepIDs, id)
+		stepPos++
+		return nil
+	case strings.TrimSpace(input) == "back":
+		if len(stepIDs) == 0 {
+			return clac.ErrNoMoreChanges
+		}
+		if err := cl.JumpTo(stepIDs[len(stepIDs)-1]); err != nil {
+			return err
+		}
+		stepIDs = stepIDs[:len(stepIDs)-1]
+		stepPos--
+		return nil
+	default:
+		stepItems = nil
+	}
+	return tuiProcessInput(input, trmState)
+}
+
+// stepInfo describes the state of step mode.
+func stepInfo() string {
+	switch {
+	case stepWait:
+		return "[ step: enter program ]"
+	case stepItems == nil:
+		return ""
+	case stepPos == len(stepItems):
+		return fmt.Sprintf("[ step done %d/%d ]", stepPos, len(stepItems))
+	}
+	return fmt.Sprintf("[ step %d/%d: %s ]", stepPos+1, len(stepItems), stepItems[stepPos])
+}
+
+// tuiProcessInput processes input with the terminal restored to its original
+// state, so Ctrl-C interrupts the running command.
+func tuiProcessInput(input string, trmState *terminal.State) error {
+	terminal.Restore(syscall.Stdin, trmState)
+	defer terminal.MakeRaw(syscall.Stdin)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return processInput(ctx, input)
+}
+
 func dmenuSetup() {
 	uiSetup()
-	cmdMap["hex"] = func() error { doHexOut = true; return nil }
-	cmdMap["dec"] = func() error { doHexOut = false; return nil }
-	cmdMap["conv"] = dmenuConv
+	cl.Register(clac.Cmd{
+		Name:     "hex",
+		Category: "session",
+		Help:     "display hexidecimal values",
+		Func:     func(*clac.Clac) error { doHexOut = true; return nil },
+	})
+	cl.Register(clac.Cmd{
+		Name:     "dec",
+		Category: "session",
+		Help:     "display decimal values",
+		Func:     func(*clac.Clac) error { doHexOut = false; return nil },
+	})
+	cl.Register(clac.Cmd{
+		Name:     "conv",
+		Arity:    1,
+		Category: "session",
+		Help:     "convert x between units",
+		Func:     dmenuConv,
+	})
 }
 
 func dmenuRun() {
@@ -214,14 +334,14 @@ func dmenuRun() {
 		if err != nil {
 			return
 		}
-		if err := processInput(string(out)); err != nil {
+		if err := processInput(context.Background(), string(out)); err != nil {
 			exec.Command("dmenu", "-p", "clac: "+err.Error()).Run()
 		}
 	}
 }
 
-func dmenuConv() error {
-	val, err := cl.Pop()
+func dmenuConv(c *clac.Clac) error {
+	val, err := c.Pop()
 	if err != nil {
 		return err
 	}
@@ -244,41 +364,127 @@ func dmenuConv() error {
 		}
 		return err
 	}
-	num, err := clac.ParseNum(strings.TrimSpace(string(out)))
+	num, err := c.ParseNum(strings.TrimSpace(string(out)))
 	if err != nil {
 		return err
 	}
-	return cl.Push(num)
+	return c.Push(num)
+}
+
+// testRun runs each script in files with a new clac instance, reports the
+// result of each assertion, and exits with status 1 if any failed.
+func testRun(files []string) {
+	pass, fail := 0, 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", file, err)
+			fail++
+			continue
+		}
+		c := clac.New()
+		c.EnableHistory(false)
+		configure(c)
+		c.OnAssert(func(a clac.Assertion) {
+			status := "PASS"
+			if a.Err != nil {
+				status = "FAIL"
+				fail++
+			} else {
+				pass++
+			}
+			desc := a.Cmd
+			if a.Err != nil {
+				desc += ": " + a.Err.Error()
+			} else if a.Msg != "" {
+				desc += ": " + a.Msg
+			}
+			fmt.Printf("%s %s:%d:%d: %s\n", status, file, a.Line, a.Col, desc)
+		})
+		if _, err := c.Run(string(data)); err != nil {
+			var opErr *clac.OpError
+			if errors.As(err, &opErr) {
+				fmt.Printf("FAIL %s:%d:%d: %v\n", file, opErr.Line, opErr.Col, err)
+			} else {
+				fmt.Printf("FAIL %s: %v\n", file, err)
+			}
+			fail++
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", pass, fail)
+	if fail > 0 {
+		os.Exit(1)
+	}
+}
+
+// configure applies the limits and float precision given by the flags to c.
+func configure(c *clac.Clac) {
+	c.SetLimits(maxDigits, maxTime)
+	if floatPrec > 0 {
+		c.SetFloatPrec(floatPrec)
+	}
 }
 
 func processCmdLine() (runMode, error) {
+	if flag.Arg(0) == "test" && scriptFile == "" {
+		return testMode, nil
+	}
 	input := ""
 	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeNamedPipe != 0 {
 		if pipeInput, err := ioutil.ReadAll(os.Stdin); err == nil {
 			input = string(pipeInput)
 		}
 	}
-	if len(flag.Args()) > 0 {
+	script := ""
+	if scriptFile != "" {
+		data, err := ioutil.ReadFile(scriptFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		script = string(data)
+	} else if len(flag.Args()) > 0 {
 		input += " " + strings.Join(flag.Args(), " ")
 	}
 	mode := cliMode
 	switch {
 	case doDmenu:
 		mode = dmenuMode
-	case doInitStack || input == "":
+	case doInitStack || (input == "" && script == ""):
 		mode = tuiMode
 	}
 	cl.EnableHistory(mode != cliMode)
-	err := processInput(string(input))
+	traceToList = mode == tuiMode
+	if doTrace || mode == tuiMode {
+		traceSetup()
+	}
+	cl.SetHistoryLimits(maxUndo, 0)
+	configure(cl)
+	if scriptFile != "" {
+		for i, arg := range flag.Args() {
+			val, err := cl.ParseNum(arg)
+			if err != nil {
+				log.Fatalf("invalid script argument: %s", arg)
+			}
+			cl.SetVar(fmt.Sprintf("$%d", i+1), val)
+		}
+	}
+	err := processInput(context.Background(), string(input))
+	if err == nil && script != "" {
+		err = processInput(context.Background(), script)
+		var opErr *clac.OpError
+		if errors.As(err, &opErr) {
+			err = fmt.Errorf("%s:%d:%d: %w", scriptFile, opErr.Line, opErr.Col, err)
+		}
+	}
 	return mode, err
 }
 
 func stackStr(stack clac.Stack) string {
 	out := ""
 	if doHexOut {
-		clac.SetFormat("%#x")
+		cl.SetFormat("%#x")
 	} else {
-		clac.SetFormat(fmt.Sprintf("%%.%dg", outPrec))
+		cl.SetFormat(fmt.Sprintf("%%.%dg", outPrec))
 	}
 	for i := range stack {
 		val := stack[len(stack)-i-1]
@@ -288,8 +494,10 @@ func stackStr(stack clac.Stack) string {
 		}
 		if err != nil {
 			out += err.Error()
+		} else if str, ok := fracStr(val); ok && !doHexOut {
+			out += str
 		} else {
-			out += clac.Sprint(val)
+			out += cl.Sprint(val)
 		}
 		if i < len(stack)-1 {
 			out += " "
@@ -298,34 +506,163 @@ func stackStr(stack clac.Stack) string {
 	return out
 }
 
-func quit() error {
-	os.Exit(0)
-	return nil
+func tuiBranches(c *clac.Clac) error {
+	hist, cur := c.History()
+	stacks := map[int]clac.Stack{}
+	for _, ent := range hist {
+		stacks[ent.ID] = ent.Stack
+	}
+	tuiList = nil
+	for i, id := range c.Branches() {
+		mark := " "
+		if id == cur {
+			mark = "*"
+		}
+		top := ""
+		if stack := stacks[id]; len(stack) > 0 {
+			top = stackStr(stack[:1])
+		}
+		tuiList = append(tuiList, fmt.Sprintf("%02d:%s state %d, depth %d: %s", i, mark, id, len(stacks[id]), top))
+	}
+	return clac.ErrNoHistUpdate
+}
+
+func tuiHist(c *clac.Clac) error {
+	tuiList = []string{}
+	for _, ent := range c.HistoryPath() {
+		top := ""
+		if len(ent.Stack) > 0 {
+			top = stackStr(ent.Stack[:1])
+		}
+		tuiList = append(tuiList, fmt.Sprintf("%4d %s  %-16s %s", ent.ID, ent.Time.Format("15:04:05"), ent.Cmd, top))
+	}
+	return clac.ErrNoHistUpdate
 }
 
-func processInput(input string) error {
-	scanner := bufio.NewScanner(strings.NewReader(input))
-	scanner.Split(bufio.ScanWords)
-	for scanner.Scan() {
-		tok := scanner.Text()
-		if num, err := clac.ParseNum(tok); err == nil {
-			if err = cl.Exec(func() error { return cl.Push(num) }); err != nil {
-				return fmt.Errorf("push: %s", err)
+func tuiCmds(c *clac.Clac) error {
+	cols, _, err := terminal.GetSize(syscall.Stdout)
+	if err != nil {
+		cols = 80
+	}
+	var cats []string
+	names := map[string][]string{}
+	for _, cmd := range c.Cmds() {
+		if names[cmd.Category] == nil {
+			cats = append(cats, cmd.Category)
+		}
+		names[cmd.Category] = append(names[cmd.Category], cmd.Name)
+	}
+	tuiList = []string{}
+	for _, cat := range cats {
+		line := cat + ":"
+		for _, name := range names[cat] {
+			if len(line)+len(name) >= cols {
+				tuiList = append(tuiList, line)
+				line = " "
 			}
-		} else if cmd, ok := cmdMap[tok]; ok {
-			if err := cl.Exec(cmd); err != nil {
-				return fmt.Errorf("%s: %s", tok, err)
+			line += " " + name
+		}
+		tuiList = append(tuiList, line)
+	}
+	return clac.ErrNoHistUpdate
+}
+
+func tuiHelp(c *clac.Clac, name string) error {
+	for _, cmd := range c.Cmds() {
+		if cmd.Name != name && !hasString(cmd.Aliases, name) {
+			continue
+		}
+		line := cmd.Name
+		if len(cmd.Aliases) > 0 {
+			line += " (" + strings.Join(cmd.Aliases, ", ") + ")"
+		}
+		line += ": " + cmd.Help
+		if cmd.Category == "user" {
+			line = ": " + cmd.Name + " " + cmd.Help + " ;"
+		}
+		tuiList = []string{line}
+		return clac.ErrNoHistUpdate
+	}
+	return clac.ErrInvalidArg
+}
+
+// tuiComplete completes the command or variable name before the cursor when
+// tab is pressed.
+func tuiComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	prefix := line[start:pos]
+	varPrefix := strings.TrimPrefix(prefix, "=")
+	var matches []string
+	if varPrefix == prefix {
+		for _, cmd := range cl.Cmds() {
+			for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+				if strings.HasPrefix(name, prefix) {
+					matches = append(matches, name)
+				}
 			}
-		} else {
-			return fmt.Errorf("invalid input: \"%s\"", tok)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	for name := range cl.Vars() {
+		if strings.HasPrefix(name, varPrefix) {
+			matches = append(matches, prefix[:len(prefix)-len(varPrefix)]+name)
+		}
+	}
+	if prefix == "" || len(matches) == 0 {
+		return "", 0, false
+	}
+	comp := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, comp) {
+			comp = comp[:len(comp)-1]
+		}
+	}
+	if len(matches) == 1 {
+		comp += " "
+	}
+	return line[:start] + comp + line[pos:], start + len(comp), true
+}
+
+func hasString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func tuiVars(c *clac.Clac) error {
+	vars := c.Vars()
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tuiList = []string{}
+	for _, name := range names {
+		tuiList = append(tuiList, fmt.Sprintf("%-16s %s", name, stackStr(clac.Stack{vars[name]})))
 	}
+	return clac.ErrNoHistUpdate
+}
+
+func quit() error {
+	os.Exit(0)
 	return nil
 }
 
+func processInput(ctx context.Context, input string) error {
+	var err error
+	if doAtomic {
+		_, err = cl.RunAtomicContext(ctx, input)
+	} else {
+		_, err = cl.RunContext(ctx, input)
+	}
+	return err
+}
+
 func tuiPrintStack(stack clac.Stack) {
 	cols, rows, err := terminal.GetSize(syscall.Stdout)
 	if err != nil {
@@ -337,19 +674,45 @@ func tuiPrintStack(stack clac.Stack) {
 	}
 	clearScreen()
 
+	if tuiList != nil {
+		tuiPrintList(tuiList, rows-2)
+		tuiList = nil
+	} else {
+		tuiPrintVals(stack, rows-2, cols)
+	}
+	info := stepInfo()
+	if lastErr != nil {
+		info += fmt.Sprintf("[ %s ]", lastErr)
+	}
+	if len(info) > cols {
+		info = info[:cols-3] + "..."
+	}
+	fmt.Println(info + strings.Repeat("-", cols-len(info)))
+	fmt.Print("\r")
+}
+
+func tuiPrintVals(stack clac.Stack, rows, cols int) {
 	dataCols := cols - 4
 	hexCols := dataCols / 2
 	floatCols := dataCols - hexCols
 	floatFmt := fmt.Sprintf("%%%d.%dg", floatCols-1, floatCols-8)
 	hexFmt := fmt.Sprintf("%%#%dx", hexCols-3)
-	for i := rows - 3; i >= 0; i-- {
+	for i := rows - 1; i >= 0; i-- {
 		line := fmt.Sprintf("%02d:", i)
 		if i < len(stack) {
-			clac.SetFormat(floatFmt)
-			line += fmt.Sprintf(fmt.Sprintf(" %%%ds", floatCols), clac.Sprint(stack[i]))
+			if _, ok := stack[i].(value.Complex); ok {
+				fmt.Println(line + tuiComplexStr(stack[i], floatCols, hexCols) + "\r")
+				continue
+			}
+			cl.SetFormat(floatFmt)
+			str := cl.Sprint(stack[i])
+			if frac, ok := fracStr(stack[i]); ok && len(frac) < floatCols {
+				str = frac
+			}
+			line += fmt.Sprintf(fmt.Sprintf(" %%%ds", floatCols), str)
 			if val, err := clac.Trunc(stack[i]); err == nil {
-				clac.SetFormat(hexFmt)
-				hexStr := fmt.Sprintf(fmt.Sprintf(" %%%ds", hexCols-1), clac.Sprint(val))
+				cl.SetFormat(hexFmt)
+				hexStr := fmt.Sprintf(fmt.Sprintf(" %%%ds", hexCols-1), cl.Sprint(val))
 				if len(hexStr) > hexCols {
 					hexStr = hexStr[:hexCols-1] + "…"
 				}
@@ -358,12 +721,45 @@ func tuiPrintStack(stack clac.Stack) {
 		}
 		fmt.Println(line + "\r")
 	}
-	info := ""
-	if lastErr != nil {
-		info = fmt.Sprintf("[ %s ]", lastErr)
+}
+
+// tuiComplexStr formats a complex value in rectangular form in place of the
+// float column, and in polar form in place of the hex column.
+func tuiComplexStr(val value.Value, floatCols, hexCols int) string {
+	// each column holds two numbers, plus signs, exponents, and separators
+	digits := func(cols int) int {
+		if cols < 18 {
+			return 1
+		}
+		return (cols - 16) / 2
+	}
+	fit := func(str string, cols int) string {
+		if runes := []rune(str); len(runes) > cols {
+			return string(runes[:cols-1]) + "…"
+		}
+		return str
+	}
+	cl.SetFormat(fmt.Sprintf("%%.%dg", digits(floatCols)))
+	str := fmt.Sprintf(fmt.Sprintf(" %%%ds", floatCols), fit(cl.Sprint(val), floatCols))
+	if mag, angle, err := clac.Polar(val); err == nil {
+		cl.SetFormat(fmt.Sprintf("%%.%dg", digits(hexCols)))
+		polar := cl.Sprint(mag) + "∠" + cl.Sprint(angle)
+		str += fmt.Sprintf(fmt.Sprintf(" %%%ds", hexCols-1), fit(polar, hexCols-1))
+	}
+	return str
+}
+
+// tuiPrintList prints the last rows lines of list, aligned to the bottom.
+func tuiPrintList(list []string, rows int) {
+	if len(list) > rows {
+		list = list[len(list)-rows:]
+	}
+	for i := len(list); i < rows; i++ {
+		fmt.Println("\r")
+	}
+	for _, line := range list {
+		fmt.Println(line + "\r")
 	}
-	fmt.Println(info + strings.Repeat("-", cols-len(info)))
-	fmt.Print("\r")
 }
 
 func clearScreen() {
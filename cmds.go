@@ -0,0 +1,179 @@
+package clac
+
+import "robpike.io/ivy/value"
+
+// Variadic is the arity of commands whose number of arguments is determined
+// by the stack.
+const Variadic = -1
+
+// Cmd describes a clac command.
+type Cmd struct {
+	Name     string              // primary name
+	Aliases  []string            // alternate names
+	Arity    int                 // number of stack values consumed, or Variadic
+	Category string              // general category, e.g. "stack" or "trigonometric"
+	Help     string              // one line description
+	Func     func(c *Clac) error // implementation, to be run via Exec
+
+	// ArgFunc, if not nil, implements a command that takes the next input
+	// word as an argument, and is used instead of Func.
+	ArgFunc func(c *Clac, arg string) error
+}
+
+var cmds = []Cmd{
+	{"neg", []string{"n"}, 1, "arithmetic", "negation of x", (*Clac).Neg, nil},
+	{"abs", []string{"a"}, 1, "arithmetic", "absolute value, or magnitude, of x", (*Clac).Abs, nil},
+	{"inv", []string{"i"}, 1, "arithmetic", "inverse of x", (*Clac).Inv, nil},
+	{"+", nil, 2, "arithmetic", "sum of y and x", (*Clac).Add, nil},
+	{"-", nil, 2, "arithmetic", "difference of y and x", (*Clac).Sub, nil},
+	{"*", []string{"x"}, 2, "arithmetic", "product of y and x", (*Clac).Mul, nil},
+	{"/", nil, 2, "arithmetic", "quotient of y divided by x", (*Clac).Div, nil},
+	{"div", nil, 2, "arithmetic", "integer quotient of y divided by x", (*Clac).IntDiv, nil},
+	{"%", nil, 2, "arithmetic", "remainder of y divided by x", (*Clac).Mod, nil},
+	{"floor", nil, 1, "arithmetic", "largest integer not greater than x", (*Clac).Floor, nil},
+	{"ceil", nil, 1, "arithmetic", "smallest integer not less than x", (*Clac).Ceil, nil},
+	{"trunc", nil, 1, "arithmetic", "x truncated toward 0", (*Clac).Trunc, nil},
+	{"rat", nil, 2, "arithmetic", "fraction nearest y with denominator at most x, or within x if x < 1", (*Clac).Rat, nil},
+	{"min", nil, 2, "arithmetic", "minimum of y and x", (*Clac).Min, nil},
+	{"max", nil, 2, "arithmetic", "maximum of y and x", (*Clac).Max, nil},
+	{"exp", nil, 1, "exponential", "e to the power of x", (*Clac).Exp, nil},
+	{"^", nil, 2, "exponential", "y to the power of x", (*Clac).Pow, nil},
+	{"2^", nil, 1, "exponential", "2 to the power of x", (*Clac).Pow2, nil},
+	{"10^", nil, 1, "exponential", "10 to the power of x", (*Clac).Pow10, nil},
+	{"logn", nil, 2, "exponential", "base x logarithm of y", (*Clac).LogN, nil},
+	{"ln", nil, 1, "exponential", "natural logarithm of x", (*Clac).Ln, nil},
+	{"log", nil, 1, "exponential", "base 10 logarithm of x", (*Clac).Log, nil},
+	{"lg", nil, 1, "exponential", "base 2 logarithm of x", (*Clac).Lg, nil},
+	{"sqrt", nil, 1, "exponential", "square root of x", (*Clac).Sqrt, nil},
+	{"!", nil, 1, "combinatorial", "factorial of x", (*Clac).Factorial, nil},
+	{"comb", nil, 2, "combinatorial", "combinations of x taken from y", (*Clac).Comb, nil},
+	{"perm", nil, 2, "combinatorial", "permutations of x taken from y", (*Clac).Perm, nil},
+	{"sin", nil, 1, "trigonometric", "sine of x", (*Clac).Sin, nil},
+	{"cos", nil, 1, "trigonometric", "cosine of x", (*Clac).Cos, nil},
+	{"tan", nil, 1, "trigonometric", "tangent of x", (*Clac).Tan, nil},
+	{"asin", nil, 1, "trigonometric", "arcsine of x", (*Clac).Asin, nil},
+	{"acos", nil, 1, "trigonometric", "arccosine of x", (*Clac).Acos, nil},
+	{"atan", nil, 1, "trigonometric", "arctangent of x", (*Clac).Atan, nil},
+	{"atan2", nil, 2, "trigonometric", "arctangent of y / x", (*Clac).Atan2, nil},
+	{"dtor", nil, 1, "trigonometric", "x converted from degrees to radians", (*Clac).DegToRad, nil},
+	{"rtod", nil, 1, "trigonometric", "x converted from radians to degrees", (*Clac).RadToDeg, nil},
+	{"rtop", nil, 2, "trigonometric", "rectangular coordinates y,x converted to polar", (*Clac).RectToPolar, nil},
+	{"ptor", nil, 2, "trigonometric", "polar coordinates y<x converted to rectangular", (*Clac).PolarToRect, nil},
+	{"hyp", nil, 2, "trigonometric", "hypotenuse of a right triangle with legs y and x", (*Clac).Hypot, nil},
+	{"re", nil, 1, "complex", "real part of x", (*Clac).Real, nil},
+	{"im", nil, 1, "complex", "imaginary part of x", (*Clac).Imag, nil},
+	{"arg", nil, 1, "complex", "argument, or angle, of x", (*Clac).Arg, nil},
+	{"conj", nil, 1, "complex", "complex conjugate of x", (*Clac).Conj, nil},
+	{"cplx", nil, 2, "complex", "complex value with real part y and imaginary part x", (*Clac).Cplx, nil},
+	{"cpolar", nil, 2, "complex", "complex value with magnitude y and angle x", (*Clac).CplxPolar, nil},
+	{"and", nil, 2, "bitwise", "bitwise and of y and x", (*Clac).And, nil},
+	{"or", nil, 2, "bitwise", "bitwise or of y and x", (*Clac).Or, nil},
+	{"xor", nil, 2, "bitwise", "bitwise exclusive or of y and x", (*Clac).Xor, nil},
+	{"not", nil, 1, "bitwise", "bitwise not of x", (*Clac).Not, nil},
+	{"andn", nil, Variadic, "bitwise", "bitwise and of the last x values", (*Clac).AndN, nil},
+	{"orn", nil, Variadic, "bitwise", "bitwise or of the last x values", (*Clac).OrN, nil},
+	{"xorn", nil, Variadic, "bitwise", "bitwise exclusive or of the last x values", (*Clac).XorN, nil},
+	{"<", nil, 2, "logical", "1 if y is less than x, else 0", (*Clac).Lt, nil},
+	{"<=", nil, 2, "logical", "1 if y is less than or equal to x, else 0", (*Clac).Le, nil},
+	{"==", nil, 2, "logical", "1 if y is equal to x, else 0", (*Clac).Eq, nil},
+	{"!=", nil, 2, "logical", "1 if y is not equal to x, else 0", (*Clac).Ne, nil},
+	{">=", nil, 2, "logical", "1 if y is greater than or equal to x, else 0", (*Clac).Ge, nil},
+	{">", nil, 2, "logical", "1 if y is greater than x, else 0", (*Clac).Gt, nil},
+	{"~=", nil, 3, "logical", "1 if z and y differ by at most x, else 0", (*Clac).ApproxEq, nil},
+	{"land", nil, 2, "logical", "1 if y and x are both non-zero, else 0", (*Clac).LogicalAnd, nil},
+	{"lor", nil, 2, "logical", "1 if y or x is non-zero, else 0", (*Clac).LogicalOr, nil},
+	{"lnot", nil, 1, "logical", "1 if x is zero, else 0", (*Clac).LogicalNot, nil},
+	{"assert", nil, 1, "test", "fail unless x is non-zero", (*Clac).Assert, nil},
+	{"expect", nil, 2, "test", "fail unless y is equal to x", (*Clac).Expect, nil},
+	{"expect~", nil, 3, "test", "fail unless z and y differ by at most x", (*Clac).ExpectNear, nil},
+	{"sum", nil, Variadic, "statistical", "sum of the last x values", (*Clac).Sum, nil},
+	{"avg", nil, Variadic, "statistical", "mean of the last x values", (*Clac).Avg, nil},
+	{"minn", nil, Variadic, "statistical", "minimum of the last x values", (*Clac).MinN, nil},
+	{"maxn", nil, Variadic, "statistical", "maximum of the last x values", (*Clac).MaxN, nil},
+	{"mag", nil, Variadic, "vector", "magnitude of the vector of the last x values", (*Clac).Mag, nil},
+	{"dot", nil, Variadic, "vector", "dot product of two vectors of size x", (*Clac).Dot, nil},
+	{"dot3", nil, 6, "vector", "dot product of two 3D vectors", (*Clac).Dot3, nil},
+	{"cross", nil, 6, "vector", "cross product of two 3D vectors", (*Clac).Cross, nil},
+	{"drop", []string{"k"}, 1, "stack", "drop x", (*Clac).Drop, nil},
+	{"dropn", nil, Variadic, "stack", "drop the last x values", (*Clac).DropN, nil},
+	{"dropr", nil, Variadic, "stack", "drop x values starting at index y", (*Clac).DropR, nil},
+	{"dup", []string{"d"}, 1, "stack", "duplicate x", (*Clac).Dup, nil},
+	{"dupn", nil, Variadic, "stack", "duplicate the last x values", (*Clac).DupN, nil},
+	{"dupr", nil, Variadic, "stack", "duplicate x values starting at index y", (*Clac).DupR, nil},
+	{"pick", []string{"p"}, 1, "stack", "duplicate the value at index x", (*Clac).Pick, nil},
+	{"swap", []string{"s"}, 2, "stack", "swap x and y", (*Clac).Swap, nil},
+	{"rot", nil, 1, "stack", "rotate the value at index x down", (*Clac).Rot, nil},
+	{"unrot", nil, 1, "stack", "rotate the value at index x up", (*Clac).Unrot, nil},
+	{"rotr", nil, Variadic, "stack", "rotate x values starting at index y down", (*Clac).RotR, nil},
+	{"unrotr", nil, Variadic, "stack", "rotate x values starting at index y up", (*Clac).UnrotR, nil},
+	{"lastx", nil, 0, "stack", "push the arguments of the last command", (*Clac).LastX, nil},
+	{"depth", nil, 0, "stack", "number of stack values", (*Clac).Depth, nil},
+	{"pi", nil, 0, "constant", "ratio of a circle's circumference to its diameter", constant(func(c *Clac) value.Value { return c.pi }), nil},
+	{"e", nil, 0, "constant", "base of the natural logarithm", constant(func(c *Clac) value.Value { return c.e }), nil},
+	{"phi", nil, 0, "constant", "golden ratio", constant(func(c *Clac) value.Value { return c.phi }), nil},
+	{"clear", []string{"c"}, 0, "session", "clear the stack", (*Clac).Clear, nil},
+	{"undo", []string{"u"}, 0, "session", "undo the last change", (*Clac).Undo, nil},
+	{"redo", []string{"r"}, 0, "session", "redo the last undone change", (*Clac).Redo, nil},
+	{"goto", nil, 0, "session", "go to the undo history state with the given id", nil, (*Clac).Goto},
+	{"branch", nil, 0, "session", "go to the tip of the given undo history branch", nil, (*Clac).Branch},
+	{"reset", nil, 0, "session", "reset to the initial state", (*Clac).Reset, nil},
+	{"unset", nil, 0, "session", "delete the named variable", nil, (*Clac).Unset},
+	{"prec", nil, 0, "session", "set the float precision to the given number of bits", nil, (*Clac).Prec},
+	{"save", nil, 0, "session", "save the session to the named file", nil, (*Clac).Save},
+	{"load", nil, 0, "session", "load the session or script from the named file", nil, (*Clac).Load},
+	{"savewords", nil, 0, "session", "save the user defined words to the named file", nil, (*Clac).SaveWords},
+}
+
+// cmdsByName holds the built-in commands by name and alias.  Lookups use it
+// rather than cmds, since commands that run input refer to lookups, and cmds
+// can't refer to itself during initialization.
+var cmdsByName = map[string]Cmd{}
+
+func init() {
+	for _, cmd := range cmds {
+		cmdsByName[cmd.Name] = cmd
+		for _, alias := range cmd.Aliases {
+			cmdsByName[alias] = cmd
+		}
+	}
+}
+
+// constant returns a command that pushes the constant value returned by val,
+// which is computed at the instance's float precision.
+func constant(val func(c *Clac) value.Value) func(c *Clac) error {
+	return func(c *Clac) error { return c.Push(val(c)) }
+}
+
+// Cmds returns the built-in commands.
+func Cmds() []Cmd {
+	return append([]Cmd{}, cmds...)
+}
+
+// LookupCmd returns the built-in command with the given name or alias.
+func LookupCmd(name string) (Cmd, bool) {
+	cmd, ok := cmdsByName[name]
+	return cmd, ok
+}
+
+// Register adds a command to the instance, replacing any existing command
+// with the same name or alias.
+func (c *Clac) Register(cmd Cmd) {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	c.cmds[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		c.cmds[alias] = cmd
+	}
+}
+
+// lookup returns the command with the given name or alias, giving precedence
+// to commands registered with the instance.
+func (c *Clac) lookup(name string) (Cmd, bool) {
+	c.cmdMu.RLock()
+	cmd, ok := c.cmds[name]
+	c.cmdMu.RUnlock()
+	if ok {
+		return cmd, true
+	}
+	return LookupCmd(name)
+}
@@ -0,0 +1,261 @@
+package clac
+
+import "robpike.io/ivy/value"
+
+// Complex values are ivy complex numbers, entered with j between the real and
+// imaginary parts, e.g. 3j4.  Results with a zero imaginary part are reduced
+// to real values.  Functions that are undefined for some real arguments, such
+// as the square root of a negative number, return complex results for them.
+
+// isComplex reports whether val is a complex value.
+func isComplex(val value.Value) bool {
+	_, ok := val.(value.Complex)
+	return ok
+}
+
+// Polar returns the magnitude and angle of the given value, using the default
+// config.
+func Polar(val value.Value) (mag, angle value.Value, err error) {
+	e := &eval{ctx: defaultCtx}
+	mag, angle = e.cabs(val), e.carg(val)
+	return mag, angle, e.err
+}
+
+// Real returns the real part of x.
+func (c *Clac) Real() error {
+	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
+		e := c.newEval()
+		re, _ := e.parts(vals[0])
+		return re, e.err
+	})
+}
+
+// Imag returns the imaginary part of x.
+func (c *Clac) Imag() error {
+	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
+		e := c.newEval()
+		_, im := e.parts(vals[0])
+		return im, e.err
+	})
+}
+
+// Arg returns the argument, or angle, of x.
+func (c *Clac) Arg() error {
+	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
+		e := c.newEval()
+		angle := e.carg(vals[0])
+		return angle, e.err
+	})
+}
+
+// Conj returns the complex conjugate of x.
+func (c *Clac) Conj() error {
+	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
+		e := c.newEval()
+		re, im := e.parts(vals[0])
+		return e.cplx(re, e.unary("-", im)), e.err
+	})
+}
+
+// Cplx returns the complex value with real part y and imaginary part x.
+func (c *Clac) Cplx() error {
+	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
+		if isComplex(vals[1]) || isComplex(vals[0]) {
+			return zero, ErrInvalidArg
+		}
+		e := c.newEval()
+		z := e.cplx(vals[1], vals[0])
+		return z, e.err
+	})
+}
+
+// CplxPolar returns the complex value with magnitude y and angle x.
+func (c *Clac) CplxPolar() error {
+	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
+		if isComplex(vals[1]) || isComplex(vals[0]) {
+			return zero, ErrInvalidArg
+		}
+		e := c.newEval()
+		mag, angle := vals[1], vals[0]
+		z := e.cplx(e.binary(mag, "*", e.unary("cos", angle)), e.binary(mag, "*", e.unary("sin", angle)))
+		return z, e.err
+	})
+}
+
+// parts returns the real and imaginary parts of z.
+func (e *eval) parts(z value.Value) (re, im value.Value) {
+	if !isComplex(z) {
+		return z, zero
+	}
+	return e.unary("real", z), e.unary("imag", z)
+}
+
+// cplx returns the complex value with real part re and imaginary part im, or
+// re if im is 0.
+func (e *eval) cplx(re, im value.Value) value.Value {
+	if isTrue(e.binary(im, "==", zero)) {
+		return re
+	}
+	return e.binary(re, "j", im)
+}
+
+// isNegReal reports whether z is a negative real value.
+func (e *eval) isNegReal(z value.Value) bool {
+	return !isComplex(z) && isTrue(e.binary(z, "<", zero))
+}
+
+// isUnit reports whether z is a real value between -1 and 1.
+func (e *eval) isUnit(z value.Value) bool {
+	return !isComplex(z) && isTrue(e.binary(e.unary("abs", z), "<=", value.Int(1)))
+}
+
+func (e *eval) cabs(z value.Value) value.Value {
+	if !isComplex(z) {
+		return e.unary("abs", z)
+	}
+	return e.hypot(e.parts(z))
+}
+
+func (e *eval) carg(z value.Value) value.Value {
+	return e.atan2(e.parts(z))
+}
+
+func (e *eval) csqrt(z value.Value) value.Value {
+	if !isComplex(z) && !e.isNegReal(z) {
+		return e.unary("sqrt", z)
+	}
+	re, im := e.parts(z)
+	mag, two := e.cabs(z), value.Int(2)
+	a := e.unary("sqrt", e.binary(e.binary(mag, "+", re), "/", two))
+	b := e.unary("sqrt", e.binary(e.binary(mag, "-", re), "/", two))
+	if isTrue(e.binary(im, "<", zero)) {
+		b = e.unary("-", b)
+	}
+	return e.cplx(a, b)
+}
+
+func (e *eval) cexp(z value.Value) value.Value {
+	if !isComplex(z) {
+		return e.unary("**", z)
+	}
+	re, im := e.parts(z)
+	mag := e.unary("**", re)
+	return e.cplx(e.binary(mag, "*", e.unary("cos", im)), e.binary(mag, "*", e.unary("sin", im)))
+}
+
+func (e *eval) cln(z value.Value) value.Value {
+	if !isComplex(z) && !e.isNegReal(z) {
+		return e.unary("log", z)
+	}
+	return e.cplx(e.unary("log", e.cabs(z)), e.carg(z))
+}
+
+// clog returns the base b logarithm of z.
+func (e *eval) clog(b, z value.Value) value.Value {
+	if !isComplex(b) && !isComplex(z) && !e.isNegReal(b) && !e.isNegReal(z) {
+		return e.binary(b, "log", z)
+	}
+	return e.binary(e.cln(z), "/", e.cln(b))
+}
+
+// cpow returns y to the power of x.  Complex values raised to integer powers
+// are calculated exactly.
+func (e *eval) cpow(y, x value.Value) value.Value {
+	if n, ok := x.(value.Int); ok && isComplex(y) {
+		return e.cipow(y, int(n))
+	}
+	if isComplex(y) || isComplex(x) ||
+		(e.isNegReal(y) && !isTrue(e.binary(x, "==", e.unary("floor", x)))) {
+		return e.cexp(e.binary(x, "*", e.cln(y)))
+	}
+	return e.binary(y, "**", x)
+}
+
+// cipow returns z to the integer power n, by repeated squaring.
+func (e *eval) cipow(z value.Value, n int) value.Value {
+	if n < 0 {
+		z, n = e.unary("/", z), -n
+	}
+	var res value.Value = value.Int(1)
+	for ; n > 0 && e.err == nil; n >>= 1 {
+		if n&1 == 1 {
+			res = e.binary(res, "*", z)
+		}
+		if n > 1 {
+			z = e.binary(z, "*", z)
+		}
+	}
+	return res
+}
+
+// coshSinh returns the hyperbolic cosine and sine of x.
+func (e *eval) coshSinh(x value.Value) (value.Value, value.Value) {
+	pos := e.unary("**", x)
+	neg, two := e.unary("/", pos), value.Int(2)
+	return e.binary(e.binary(pos, "+", neg), "/", two), e.binary(e.binary(pos, "-", neg), "/", two)
+}
+
+func (e *eval) csin(z value.Value) value.Value {
+	if !isComplex(z) {
+		return e.unary("sin", z)
+	}
+	re, im := e.parts(z)
+	cosh, sinh := e.coshSinh(im)
+	return e.cplx(e.binary(e.unary("sin", re), "*", cosh), e.binary(e.unary("cos", re), "*", sinh))
+}
+
+func (e *eval) ccos(z value.Value) value.Value {
+	if !isComplex(z) {
+		return e.unary("cos", z)
+	}
+	re, im := e.parts(z)
+	cosh, sinh := e.coshSinh(im)
+	return e.cplx(e.binary(e.unary("cos", re), "*", cosh), e.unary("-", e.binary(e.unary("sin", re), "*", sinh)))
+}
+
+func (e *eval) ctan(z value.Value) value.Value {
+	if !isComplex(z) {
+		return e.unary("tan", z)
+	}
+	return e.binary(e.csin(z), "/", e.ccos(z))
+}
+
+func (e *eval) casin(z value.Value) value.Value {
+	if e.isUnit(z) {
+		return e.unary("asin", z)
+	}
+	if !isComplex(z) {
+		// real values beyond ±1 take the branch with a positive imaginary
+		// part: ±π/2 + i ln(|z| + sqrt(z² - 1))
+		mag := e.unary("abs", z)
+		re := e.binary(e.unary("sgn", z), "*", e.binary(e.pi(), "/", value.Int(2)))
+		root := e.unary("sqrt", e.binary(e.binary(mag, "*", mag), "-", value.Int(1)))
+		return e.cplx(re, e.unary("log", e.binary(mag, "+", root)))
+	}
+	// asin z = -i ln(iz + sqrt(1 - z²))
+	re, im := e.parts(z)
+	iz := e.cplx(e.unary("-", im), re)
+	root := e.csqrt(e.binary(value.Int(1), "-", e.binary(z, "*", z)))
+	lnRe, lnIm := e.parts(e.cln(e.binary(iz, "+", root)))
+	return e.cplx(lnIm, e.unary("-", lnRe))
+}
+
+func (e *eval) cacos(z value.Value) value.Value {
+	if e.isUnit(z) {
+		return e.unary("acos", z)
+	}
+	// acos z = π/2 - asin z
+	return e.binary(e.binary(e.pi(), "/", value.Int(2)), "-", e.casin(z))
+}
+
+func (e *eval) catan(z value.Value) value.Value {
+	if !isComplex(z) {
+		return e.unary("atan", z)
+	}
+	// atan z = i/2 ln((i + z) / (i - z))
+	i := e.cplx(zero, value.Int(1))
+	q := e.binary(e.binary(i, "+", z), "/", e.binary(i, "-", z))
+	lnRe, lnIm := e.parts(e.cln(q))
+	two := value.Int(2)
+	return e.cplx(e.unary("-", e.binary(lnIm, "/", two)), e.binary(lnRe, "/", two))
+}
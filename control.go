@@ -0,0 +1,146 @@
+package clac
+
+import "robpike.io/ivy/value"
+
+// controls holds the number of blocks taken by each control construct.
+var controls = map[string]int{
+	"if":     1,
+	"ifelse": 2,
+	"times":  1,
+	"while":  2,
+}
+
+// isReserved reports whether name is part of the syntax of the RPN language.
+func isReserved(name string) bool {
+	switch name {
+	case ":", ";", "[", "]":
+		return true
+	}
+	_, ok := controls[name]
+	return ok
+}
+
+// parseBlock returns the tokens enclosed by the brackets at the start of
+// toks, and the number of tokens consumed.  Blocks may be nested.
+func parseBlock(toks []token) ([]token, int, error) {
+	depth := 0
+	for i, tok := range toks {
+		switch tok.text {
+		case "[":
+			depth++
+		case "]":
+			depth--
+			if depth == 0 {
+				return toks[1:i], i + 1, nil
+			}
+		}
+	}
+	return nil, 0, ErrMissingArg
+}
+
+// control returns a function that executes the named control construct on
+// blocks as a single command.
+func (c *Clac) control(name string, blocks [][]token) (func() error, error) {
+	n, ok := controls[name]
+	if !ok {
+		return nil, ErrInvalidInput
+	}
+	if len(blocks) != n {
+		return nil, ErrInvalidArg
+	}
+	return func() error {
+		return c.atomic(func() (bool, error) {
+			r := &blockRun{c: c}
+			var err error
+			switch name {
+			case "if":
+				err = r.ifElse(blocks[0], nil)
+			case "ifelse":
+				err = r.ifElse(blocks[0], blocks[1])
+			case "times":
+				err = r.times(blocks[0])
+			case "while":
+				err = r.while(blocks[0], blocks[1])
+			}
+			return r.changed, err
+		})
+	}, nil
+}
+
+// blockRun executes the blocks of a control construct, tracking whether the
+// working stack or variables were changed, as for runSteps.
+type blockRun struct {
+	c       *Clac
+	changed bool
+}
+
+func (r *blockRun) run(block []token) error {
+	hist, cur := r.c.hist, r.c.hist.cur
+	changed, err := r.c.runSteps(block)
+	if r.c.hist != hist || r.c.hist.cur != cur {
+		r.changed = changed
+	} else {
+		r.changed = r.changed || changed
+	}
+	return err
+}
+
+func (r *blockRun) pop() (value.Value, error) {
+	x, err := r.c.Pop()
+	if err == nil {
+		r.changed = true
+	}
+	return x, err
+}
+
+func (r *blockRun) ifElse(then, els []token) error {
+	x, err := r.pop()
+	if err != nil {
+		return err
+	}
+	if isTrue(x) {
+		return r.run(then)
+	}
+	if els != nil {
+		return r.run(els)
+	}
+	return nil
+}
+
+func (r *blockRun) times(body []token) error {
+	n, err := r.c.popIntMin(0)
+	if err != nil {
+		return err
+	}
+	r.changed = true
+	for i := 0; i < n; i++ {
+		if err := r.c.interrupted(); err != nil {
+			return err
+		}
+		if err := r.run(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *blockRun) while(cond, body []token) error {
+	for {
+		if err := r.c.interrupted(); err != nil {
+			return err
+		}
+		if err := r.run(cond); err != nil {
+			return err
+		}
+		x, err := r.pop()
+		if err != nil {
+			return err
+		}
+		if !isTrue(x) {
+			return nil
+		}
+		if err := r.run(body); err != nil {
+			return err
+		}
+	}
+}
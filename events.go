@@ -0,0 +1,99 @@
+package clac
+
+import "robpike.io/ivy/value"
+
+// EventKind identifies the kind of change reported by an Event.
+type EventKind int
+
+const (
+	EventExec  EventKind = iota // a command was executed
+	EventUndo                   // a change was undone
+	EventRedo                   // a change was redone
+	EventJump                   // another state in the undo history was made current
+	EventReset                  // clac was reset
+	EventLoad                   // a session was loaded
+)
+
+var eventKindNames = []string{"exec", "undo", "redo", "jump", "reset", "load"}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKindNames) {
+		return "unknown"
+	}
+	return eventKindNames[k]
+}
+
+// Event describes a change to the state of a Clac.
+type Event struct {
+	Kind   EventKind
+	Seq    uint64        // sequence number, increasing in order of changes
+	Cmd    string        // command, if known, for EventExec
+	Popped []value.Value // values removed from the top of the stack, top first
+	Pushed []value.Value // values added to the top of the stack, top first
+	Stack  Stack         // resulting stack
+}
+
+// Subscribe registers f to be called after each change to the state of the
+// Clac, and returns a function that cancels the subscription.  Events are
+// delivered after the command that caused them completes, so f may call any
+// Clac method.  Events for commands executed concurrently may be delivered
+// concurrently, and out of order; Seq gives their actual order.
+func (c *Clac) Subscribe(f func(Event)) (cancel func()) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	id := c.nextSub
+	c.nextSub++
+	c.subs[id] = f
+	return func() {
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+		delete(c.subs, id)
+	}
+}
+
+// addEvent queues an event describing the change from the before state to
+// the current state, if there was one.
+func (c *Clac) addEvent(cmd string, before histState) {
+	after := c.hist.states[c.hist.cur]
+	if c.evKind == EventExec && after.id == before.id && after.top == before.top {
+		return
+	}
+	c.seq++
+	ev := Event{Kind: c.evKind, Seq: c.seq, Stack: after.top.toStack()}
+	if ev.Kind == EventExec {
+		ev.Cmd = cmd
+	}
+	ev.Popped, ev.Pushed = stackDiff(before.top.toStack(), ev.Stack)
+	c.events = append(c.events, ev)
+}
+
+// stackDiff returns the values removed from the top of old and added to
+// produce new.
+func stackDiff(old, new Stack) (popped, pushed []value.Value) {
+	n := 0
+	for n < len(old) && n < len(new) && sameVal(old[len(old)-n-1], new[len(new)-n-1]) {
+		n++
+	}
+	return old[:len(old)-n], new[:len(new)-n]
+}
+
+// unlock unlocks c.mu, then delivers any queued events.
+func (c *Clac) unlock() {
+	events := c.events
+	c.events = nil
+	c.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	c.subMu.Lock()
+	subs := make([]func(Event), 0, len(c.subs))
+	for _, f := range c.subs {
+		subs = append(subs, f)
+	}
+	c.subMu.Unlock()
+	for _, ev := range events {
+		for _, f := range subs {
+			f(ev)
+		}
+	}
+}
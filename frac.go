@@ -0,0 +1,111 @@
+package clac
+
+import (
+	"math/big"
+
+	"robpike.io/ivy/value"
+)
+
+// SprintFrac returns an integer or rational value as an exact fraction, such
+// as -7/3, or as a mixed number, such as -2 1/3, if mixed is true.  It
+// returns false for other values.
+func SprintFrac(val value.Value, mixed bool) (string, bool) {
+	r, ok := exactRat(val)
+	if !ok {
+		return "", false
+	}
+	if r.IsInt() {
+		return r.Num().String(), true
+	}
+	whole, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if !mixed || whole.Sign() == 0 {
+		return r.String(), true
+	}
+	return whole.String() + " " + rem.Abs(rem).String() + "/" + r.Denom().String(), true
+}
+
+// Rat returns the fraction nearest y with a denominator of at most x, if x
+// is at least 1, or else the simplest fraction found within x of y.
+func (c *Clac) Rat() error {
+	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
+		x, ok := toRat(vals[1])
+		if !ok {
+			return zero, ErrInvalidArg
+		}
+		bound, ok := toRat(vals[0])
+		if !ok || bound.Sign() <= 0 {
+			return zero, ErrInvalidArg
+		}
+		var r *big.Rat
+		if bound.Cmp(big.NewRat(1, 1)) >= 0 {
+			r = approxRat(x, new(big.Int).Quo(bound.Num(), bound.Denom()), nil)
+		} else {
+			r = approxRat(x, nil, bound)
+		}
+		return canonical(c.ctx, value.BigRat{Rat: r})
+	})
+}
+
+// exactRat returns the value of an integer or rational as a big.Rat.
+func exactRat(val value.Value) (*big.Rat, bool) {
+	switch v := val.(type) {
+	case value.Int:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case value.BigInt:
+		return new(big.Rat).SetInt(v.Int), true
+	case value.BigRat:
+		return new(big.Rat).Set(v.Rat), true
+	}
+	return nil, false
+}
+
+// toRat returns the exact value of a number as a big.Rat.
+func toRat(val value.Value) (*big.Rat, bool) {
+	if r, ok := exactRat(val); ok {
+		return r, true
+	}
+	if f, ok := val.(value.BigFloat); ok {
+		r, _ := f.Float.Rat(nil)
+		return r, r != nil
+	}
+	return nil, false
+}
+
+// approxRat returns the best rational approximation of x with a denominator
+// of at most maxDen if maxDen is not nil, or else the first continued
+// fraction convergent of x within tol of x.
+func approxRat(x *big.Rat, maxDen *big.Int, tol *big.Rat) *big.Rat {
+	p0, q0, p1, q1 := big.NewInt(0), big.NewInt(1), big.NewInt(1), big.NewInt(0)
+	n, d := new(big.Int).Set(x.Num()), new(big.Int).Set(x.Denom())
+	a, t := new(big.Int), new(big.Int)
+	for d.Sign() != 0 {
+		a.Div(n, d)
+		q2 := new(big.Int).Add(q0, t.Mul(a, q1))
+		if maxDen != nil && q2.Cmp(maxDen) > 0 {
+			// the best approximation is either the last convergent or the
+			// semiconvergent with the largest allowed denominator
+			k := new(big.Int).Quo(t.Sub(maxDen, q0), q1)
+			semi := new(big.Rat).SetFrac(
+				new(big.Int).Add(p0, new(big.Int).Mul(k, p1)),
+				new(big.Int).Add(q0, new(big.Int).Mul(k, q1)))
+			conv := new(big.Rat).SetFrac(p1, q1)
+			if ratDist(x, semi).Cmp(ratDist(x, conv)) < 0 {
+				return semi
+			}
+			return conv
+		}
+		p2 := new(big.Int).Add(p0, t.Mul(a, p1))
+		p0, q0, p1, q1 = p1, q1, p2, q2
+		n, d = d, new(big.Int).Sub(n, t.Mul(a, d))
+		if tol != nil && ratDist(x, new(big.Rat).SetFrac(p1, q1)).Cmp(tol) <= 0 {
+			break
+		}
+	}
+	return new(big.Rat).SetFrac(p1, q1)
+}
+
+// ratDist returns the absolute difference of a and b.
+func ratDist(a, b *big.Rat) *big.Rat {
+	d := new(big.Rat).Sub(a, b)
+	return d.Abs(d)
+}
@@ -0,0 +1,417 @@
+package clac
+
+import (
+	"sort"
+	"time"
+
+	"robpike.io/ivy/value"
+)
+
+// stackNode is a node of an immutable linked stack.  Stacks in the history
+// share nodes for the values at the bottom they have in common, so each step
+// only stores the values it changed.
+type stackNode struct {
+	val   value.Value
+	next  *stackNode
+	depth int
+}
+
+// toStack returns the values of the linked stack as a Stack.
+func (n *stackNode) toStack() Stack {
+	stack := make(Stack, 0, n.len())
+	for ; n != nil; n = n.next {
+		stack = append(stack, n.val)
+	}
+	return stack
+}
+
+// newStackNode returns a linked stack holding the values of stack, sharing
+// as many nodes as possible with base.
+func newStackNode(base *stackNode, stack Stack) *stackNode {
+	// find the top of the run of base nodes matching the bottom of stack
+	var shared *stackNode
+	for n := base; n != nil; n = n.next {
+		if n.depth > len(stack) {
+			continue
+		}
+		if !sameVal(n.val, stack[len(stack)-n.depth]) {
+			shared = nil
+		} else if shared == nil {
+			shared = n
+		}
+	}
+	top := shared
+	for i := len(stack) - shared.len() - 1; i >= 0; i-- {
+		top = &stackNode{val: stack[i], next: top, depth: top.len() + 1}
+	}
+	return top
+}
+
+// len returns the number of values in the linked stack.
+func (n *stackNode) len() int {
+	if n == nil {
+		return 0
+	}
+	return n.depth
+}
+
+// sameVal reports whether a and b are the same value.  It is conservative,
+// only reporting values that are known to be identical.
+func sameVal(a, b value.Value) bool {
+	switch a := a.(type) {
+	case value.Int:
+		b, ok := b.(value.Int)
+		return ok && a == b
+	case value.BigInt:
+		b, ok := b.(value.BigInt)
+		return ok && a.Int == b.Int
+	case value.BigRat:
+		b, ok := b.(value.BigRat)
+		return ok && a.Rat == b.Rat
+	case value.BigFloat:
+		b, ok := b.(value.BigFloat)
+		return ok && a.Float == b.Float
+	case value.Complex:
+		if _, ok := b.(value.Complex); !ok {
+			return false
+		}
+		e := &eval{ctx: defaultCtx}
+		aRe, aIm := e.parts(a)
+		bRe, bIm := e.parts(b)
+		return e.err == nil && sameVal(aRe, bRe) && sameVal(aIm, bIm)
+	}
+	return false
+}
+
+// nodeSize returns the approximate size in bytes of a node holding val.
+func nodeSize(val value.Value) int {
+	const nodeOverhead, bigOverhead, wordSize = 40, 32, 8
+	switch v := val.(type) {
+	case value.BigInt:
+		return nodeOverhead + bigOverhead + len(v.Int.Bits())*wordSize
+	case value.BigRat:
+		return nodeOverhead + 2*bigOverhead + (len(v.Rat.Num().Bits())+len(v.Rat.Denom().Bits()))*wordSize
+	case value.BigFloat:
+		return nodeOverhead + bigOverhead + int(v.Float.Prec()+7)/8
+	case value.Complex:
+		e := &eval{ctx: defaultCtx}
+		re, im := e.parts(v)
+		return nodeSize(re) + nodeSize(im)
+	}
+	return nodeOverhead
+}
+
+// HistEntry is a state in the undo history.
+type HistEntry struct {
+	ID     int       `json:"id"`     // unique id, increasing in order of creation
+	Parent int       `json:"parent"` // id of the state this one was derived from, or -1
+	Cmd    string    `json:"cmd"`    // command that produced the state
+	Time   time.Time `json:"time"`   // time the state was produced
+	Stack  Stack     `json:"stack"`
+	Vars   Vars      `json:"vars,omitempty"`
+}
+
+// histState is a stack in the history.
+type histState struct {
+	id     int
+	parent int
+	cmd    string
+	time   time.Time
+	top    *stackNode
+	vars   Vars // never modified, as it may be shared with other states
+}
+
+// stackHist is the undo history, a tree of states in which each command adds
+// a child of the current state.  Undo moves to the parent of the current
+// state, and redo moves to the child most recently visited.  The methods
+// never modify existing elements of states, so a published states slice
+// remains valid after later changes.
+type stackHist struct {
+	cur    int         // index of the current state
+	states []histState // in order of creation
+	redoTo map[int]int // id of the child to redo to, by parent id
+	nextID int
+	refs   map[*stackNode]int // number of states and nodes referring to each node
+	size   int                // approximate size in bytes of the nodes of states
+}
+
+func newStackHist() *stackHist {
+	return &stackHist{
+		states: []histState{{parent: -1, time: time.Now()}},
+		redoTo: map[int]int{},
+		nextID: 1,
+		refs:   map[*stackNode]int{},
+	}
+}
+
+// newStackHistFrom returns a history holding entries, with the entry with
+// id cur current.
+func newStackHistFrom(entries []HistEntry, cur int) (*stackHist, error) {
+	s := &stackHist{
+		states: make([]histState, 0, len(entries)),
+		redoTo: map[int]int{},
+		refs:   map[*stackNode]int{},
+	}
+	for _, ent := range entries {
+		// parents precede their children, so the history has no cycles
+		if ent.ID < s.nextID || ent.Parent < -1 || ent.Parent >= ent.ID {
+			return nil, ErrInvalidSession
+		}
+		var base *stackNode
+		if p, ok := s.index(ent.Parent); ok {
+			base = s.states[p].top
+		}
+		top := newStackNode(base, ent.Stack)
+		s.hold(top)
+		s.states = append(s.states, histState{
+			id:     ent.ID,
+			parent: ent.Parent,
+			cmd:    ent.Cmd,
+			time:   ent.Time,
+			top:    top,
+			vars:   ent.Vars,
+		})
+		s.redoTo[ent.Parent] = ent.ID
+		s.nextID = ent.ID + 1
+	}
+	if !s.jump(cur) {
+		return nil, ErrInvalidSession
+	}
+	return s, nil
+}
+
+// clone returns a copy of the history that is unaffected by later changes
+// to s.
+func (s *stackHist) clone() *stackHist {
+	t := *s
+	t.redoTo = make(map[int]int, len(s.redoTo))
+	for parent, id := range s.redoTo {
+		t.redoTo[parent] = id
+	}
+	t.refs = make(map[*stackNode]int, len(s.refs))
+	for n, refs := range s.refs {
+		t.refs[n] = refs
+	}
+	return &t
+}
+
+// index returns the index of the state with the given id.
+func (s *stackHist) index(id int) (int, bool) {
+	return stateIndex(s.states, id)
+}
+
+func stateIndex(states []histState, id int) (int, bool) {
+	i := sort.Search(len(states), func(i int) bool { return states[i].id >= id })
+	return i, i < len(states) && states[i].id == id
+}
+
+func (s *stackHist) undo() bool {
+	i, ok := s.index(s.states[s.cur].parent)
+	if !ok {
+		return false
+	}
+	s.cur = i
+	return true
+}
+
+func (s *stackHist) redo() bool {
+	id, ok := s.redoTo[s.states[s.cur].id]
+	if !ok {
+		return false
+	}
+	i, ok := s.index(id)
+	if !ok {
+		return false
+	}
+	s.cur = i
+	return true
+}
+
+// jump makes the state with the given id current, and updates the redo path
+// from its ancestors to lead to it.
+func (s *stackHist) jump(id int) bool {
+	i, ok := s.index(id)
+	if !ok {
+		return false
+	}
+	s.cur = i
+	for st := s.states[i]; ; {
+		s.redoTo[st.parent] = st.id
+		p, ok := s.index(st.parent)
+		if !ok {
+			break
+		}
+		st = s.states[p]
+	}
+	return true
+}
+
+func (s *stackHist) push(stack Stack, vars Vars, cmd string) {
+	parent := s.states[s.cur]
+	top := newStackNode(parent.top, stack)
+	s.hold(top)
+	s.states = append(s.states, histState{
+		id:     s.nextID,
+		parent: parent.id,
+		cmd:    cmd,
+		time:   time.Now(),
+		top:    top,
+		vars:   vars,
+	})
+	s.redoTo[parent.id] = s.nextID
+	s.nextID++
+	s.cur = len(s.states) - 1
+}
+
+func (s *stackHist) replace(stack Stack, vars Vars, cmd string) {
+	st := s.states[s.cur]
+	top := newStackNode(st.top, stack)
+	s.hold(top)
+	s.release(st.top)
+	st.cmd, st.time, st.top, st.vars = cmd, time.Now(), top, vars
+	s.states = append([]histState{}, s.states...)
+	s.states[s.cur] = st
+}
+
+// hold adds a reference to the linked stack n, adding the size of any nodes
+// not already referred to.
+func (s *stackHist) hold(n *stackNode) {
+	for ; n != nil; n = n.next {
+		s.refs[n]++
+		if s.refs[n] > 1 {
+			return
+		}
+		s.size += nodeSize(n.val)
+	}
+}
+
+// release removes a reference to the linked stack n, subtracting the size of
+// any nodes no longer referred to.
+func (s *stackHist) release(n *stackNode) {
+	for ; n != nil; n = n.next {
+		s.refs[n]--
+		if s.refs[n] > 0 {
+			return
+		}
+		delete(s.refs, n)
+		s.size -= nodeSize(n.val)
+	}
+}
+
+// trim discards states until the history holds at most maxSteps undo steps
+// and approximately maxBytes of values.  A zero limit is ignored.  It keeps
+// the current state, and discards the states the current state can't undo
+// or redo to first, oldest first, then the states it can undo to, oldest
+// first, then the states it can redo to, furthest first.
+func (s *stackHist) trim(maxSteps, maxBytes int) {
+	n := len(s.states)
+	over := func() bool {
+		return (maxSteps > 0 && n-1 > maxSteps) || (maxBytes > 0 && s.size > maxBytes)
+	}
+	if !over() {
+		return
+	}
+	cur := s.states[s.cur].id
+	drop := map[int]bool{}
+	for _, i := range s.trimOrder() {
+		if !over() {
+			break
+		}
+		drop[s.states[i].id] = true
+		s.release(s.states[i].top)
+		n--
+	}
+	kept := make([]histState, 0, n)
+	for _, st := range s.states {
+		if !drop[st.id] {
+			kept = append(kept, st)
+		}
+	}
+	s.states = kept
+	s.cur, _ = s.index(cur)
+}
+
+// trimOrder returns the indexes of the states other than the current one, in
+// the order trim discards them.
+func (s *stackHist) trimOrder() []int {
+	var undos, redos []int
+	onPath := map[int]bool{s.cur: true}
+	for i, ok := s.index(s.states[s.cur].parent); ok; i, ok = s.index(s.states[i].parent) {
+		undos = append(undos, i)
+		onPath[i] = true
+	}
+	for id, ok := s.redoTo[s.states[s.cur].id]; ok; id, ok = s.redoTo[id] {
+		i, ok := s.index(id)
+		if !ok {
+			break
+		}
+		redos = append(redos, i)
+		onPath[i] = true
+	}
+	order := make([]int, 0, len(s.states)-1)
+	for i := range s.states {
+		if !onPath[i] {
+			order = append(order, i)
+		}
+	}
+	for i := len(undos) - 1; i >= 0; i-- {
+		order = append(order, undos[i])
+	}
+	for i := len(redos) - 1; i >= 0; i-- {
+		order = append(order, redos[i])
+	}
+	return order
+}
+
+func (s *stackHist) stack() Stack {
+	return s.states[s.cur].top.toStack()
+}
+
+func (s *stackHist) vars() Vars {
+	return s.states[s.cur].vars
+}
+
+func (s *stackHist) entries() []HistEntry {
+	return statesToEntries(s.states)
+}
+
+func statesToEntries(states []histState) []HistEntry {
+	entries := make([]HistEntry, len(states))
+	for i, st := range states {
+		entries[i] = HistEntry{
+			ID:     st.id,
+			Parent: st.parent,
+			Cmd:    st.cmd,
+			Time:   st.time,
+			Stack:  st.top.toStack(),
+			Vars:   st.vars.clone(),
+		}
+	}
+	return entries
+}
+
+// tips returns the ids of the states at the tips of the branches of the
+// history, which are the states without children, in order of the creation
+// of their branches.  The first child of a state continues its branch, and
+// later children start new branches, so extending a branch doesn't change
+// its position.
+func tips(states []histState) []int {
+	start := make(map[int]int, len(states)) // id of the first state of each state's branch
+	parents := map[int]bool{}
+	for _, st := range states {
+		if id, ok := start[st.parent]; ok && !parents[st.parent] {
+			start[st.id] = id
+		} else {
+			start[st.id] = st.id
+		}
+		parents[st.parent] = true
+	}
+	var ids []int
+	for _, st := range states {
+		if !parents[st.id] {
+			ids = append(ids, st.id)
+		}
+	}
+	sort.SliceStable(ids, func(i, j int) bool { return start[ids[i]] < start[ids[j]] })
+	return ids
+}
@@ -0,0 +1,67 @@
+package clac
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestTrimKeepsRedo(t *testing.T) {
+	c := New()
+	c.EnableHistory(true)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Run(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		if _, err := c.Run("undo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.SetHistoryLimits(3, 0)
+	hist, cur := c.History()
+	var ids []int
+	for _, ent := range hist {
+		ids = append(ids, ent.ID)
+	}
+	if want := []int{4, 5, 6, 7}; cur != 4 || !reflect.DeepEqual(ids, want) {
+		t.Fatalf("history: got %v at %d, want %v at %d", ids, cur, want, 4)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Run("redo"); err != nil {
+			t.Fatalf("redo %d: %v", i, err)
+		}
+	}
+	if got := stackText(t, c.Stack()); got != "0 1 2 3 4 5 6" {
+		t.Errorf("stack: got %q", got)
+	}
+}
+
+func TestTrimSize(t *testing.T) {
+	c := New()
+	c.EnableHistory(true)
+	c.SetHistoryLimits(0, 600)
+	for _, input := range []string{
+		"1 2 3", "4 5 6", "+", "undo", "*", "undo", "undo", "7 8",
+		"2 200 ^", "dup *", "1/3", "=v", "10 !", "clear", "undo",
+	} {
+		if _, err := c.Run(input); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		seen := map[*stackNode]bool{}
+		size := 0
+		for _, st := range c.hist.states {
+			for n := st.top; n != nil && !seen[n]; n = n.next {
+				seen[n] = true
+				size += nodeSize(n.val)
+			}
+		}
+		if c.hist.size != size || len(c.hist.refs) != len(seen) {
+			t.Fatalf("%s: size %d of %d nodes, want %d of %d", input, c.hist.size, len(c.hist.refs), size, len(seen))
+		}
+		if size > 600 && len(c.hist.states) > 1 {
+			t.Fatalf("%s: size %d over limit with %d states", input, size, len(c.hist.states))
+		}
+	}
+}
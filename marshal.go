@@ -0,0 +1,186 @@
+package clac
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"robpike.io/ivy/value"
+)
+
+// The canonical text form of a stack lists its values from the bottom of the
+// stack to the top, the order in which they would be entered, separated by
+// spaces.  Values are encoded exactly:
+//
+//	integers  decimal digits, e.g. -42
+//	rationals numerator/denominator, e.g. 1/3
+//	floats    hexadecimal mantissa and binary exponent, followed by @ and the
+//	          precision in bits, e.g. 0x.dp+2@256
+//	complex   real and imaginary parts, separated by j, e.g. 3j-1/2
+//
+// The JSON form is an array of values in the same order and encoding.
+// Variables are encoded as a JSON object mapping names to encoded values.
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Stack) MarshalText() ([]byte, error) {
+	strs, err := s.marshalVals()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(strs, " ")), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Stack) UnmarshalText(text []byte) error {
+	return s.unmarshalVals(strings.Fields(string(text)))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Stack) MarshalJSON() ([]byte, error) {
+	strs, err := s.marshalVals()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(strs)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Stack) UnmarshalJSON(data []byte) error {
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return err
+	}
+	return s.unmarshalVals(strs)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Vars) MarshalJSON() ([]byte, error) {
+	strs := make(map[string]string, len(v))
+	for name, val := range v {
+		str, err := marshalVal(val)
+		if err != nil {
+			return nil, err
+		}
+		strs[name] = str
+	}
+	return json.Marshal(strs)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Vars) UnmarshalJSON(data []byte) error {
+	var strs map[string]string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return err
+	}
+	vars := make(Vars, len(strs))
+	for name, str := range strs {
+		val, err := unmarshalVal(str)
+		if err != nil {
+			return err
+		}
+		vars[name] = val
+	}
+	*v = vars
+	return nil
+}
+
+func (s Stack) marshalVals() ([]string, error) {
+	strs := make([]string, len(s))
+	for i, val := range s {
+		str, err := marshalVal(val)
+		if err != nil {
+			return nil, err
+		}
+		strs[len(s)-i-1] = str
+	}
+	return strs, nil
+}
+
+func (s *Stack) unmarshalVals(strs []string) error {
+	stack := make(Stack, len(strs))
+	for i, str := range strs {
+		val, err := unmarshalVal(str)
+		if err != nil {
+			return err
+		}
+		stack[len(strs)-i-1] = val
+	}
+	*s = stack
+	return nil
+}
+
+func marshalVal(val value.Value) (string, error) {
+	switch v := val.(type) {
+	case value.Int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case value.BigInt:
+		return v.Int.String(), nil
+	case value.BigRat:
+		return v.Rat.String(), nil
+	case value.BigFloat:
+		return v.Float.Text('p', 0) + "@" + strconv.FormatUint(uint64(v.Float.Prec()), 10), nil
+	case value.Complex:
+		e := &eval{ctx: defaultCtx}
+		re, im := e.parts(v)
+		if e.err != nil {
+			return "", e.err
+		}
+		reStr, err := marshalVal(re)
+		if err != nil {
+			return "", err
+		}
+		imStr, err := marshalVal(im)
+		if err != nil {
+			return "", err
+		}
+		return reStr + "j" + imStr, nil
+	}
+	return "", fmt.Errorf("cannot marshal %T value", val)
+}
+
+func unmarshalVal(str string) (value.Value, error) {
+	if i := strings.IndexByte(str, 'j'); i >= 0 {
+		re, err := unmarshalVal(str[:i])
+		if err != nil {
+			return nil, err
+		}
+		im, err := unmarshalVal(str[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		if isComplex(im) {
+			return nil, fmt.Errorf("invalid complex: %q", str)
+		}
+		e := &eval{ctx: defaultCtx}
+		val := e.cplx(re, im)
+		return val, e.err
+	}
+	if i := strings.IndexByte(str, '@'); i >= 0 {
+		prec, err := strconv.ParseUint(str[i+1:], 10, 32)
+		if err != nil || prec == 0 {
+			return nil, fmt.Errorf("invalid float precision: %q", str)
+		}
+		f := new(big.Float).SetPrec(uint(prec))
+		if _, _, err := f.Parse(str[:i], 0); err != nil {
+			return nil, fmt.Errorf("invalid float: %q", str)
+		}
+		return value.BigFloat{Float: f}, nil
+	}
+	var val value.Value
+	if strings.Contains(str, "/") {
+		r, ok := new(big.Rat).SetString(str)
+		if !ok {
+			return nil, fmt.Errorf("invalid rational: %q", str)
+		}
+		val = value.BigRat{Rat: r}
+	} else {
+		n, ok := new(big.Int).SetString(str, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer: %q", str)
+		}
+		val = value.BigInt{Int: n}
+	}
+	return canonical(defaultCtx, val)
+}
@@ -0,0 +1,100 @@
+package clac
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"robpike.io/ivy/value"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	e := &eval{ctx: defaultCtx}
+	big100 := new(big.Int).Lsh(big.NewInt(1), 100)
+	third := value.BigRat{Rat: big.NewRat(-1, 3)}
+	f53 := value.BigFloat{Float: new(big.Float).SetPrec(53).SetFloat64(0.1)}
+	f256, _, _ := new(big.Float).SetPrec(256).Parse("-3.14159265358979323846264338327950288", 10)
+	vals := []struct {
+		name string
+		val  value.Value
+	}{
+		{"int", value.Int(-42)},
+		{"bigint", value.BigInt{Int: big100}},
+		{"bigrat", third},
+		{"bigfloat53", f53},
+		{"bigfloat256", value.BigFloat{Float: f256}},
+		{"complex int", e.cplx(value.Int(3), value.Int(-4))},
+		{"complex rat", e.cplx(third, value.BigInt{Int: big100})},
+		{"complex float", e.cplx(value.BigFloat{Float: f256}, f53)},
+	}
+	if e.err != nil {
+		t.Fatal(e.err)
+	}
+	for _, tc := range vals {
+		stack := Stack{tc.val, value.Int(1)}
+
+		text, err := stack.MarshalText()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var fromText Stack
+		if err := fromText.UnmarshalText(text); err != nil {
+			t.Fatalf("%s: %q: %v", tc.name, text, err)
+		}
+		if len(fromText) != len(stack) || !sameExact(fromText[0], tc.val) {
+			t.Errorf("%s: text %q: got %v, want %v", tc.name, text, fromText, stack)
+		}
+
+		data, err := json.Marshal(stack)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var fromJSON Stack
+		if err := json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatalf("%s: %s: %v", tc.name, data, err)
+		}
+		if len(fromJSON) != len(stack) || !sameExact(fromJSON[0], tc.val) {
+			t.Errorf("%s: JSON %s: got %v, want %v", tc.name, data, fromJSON, stack)
+		}
+
+		data, err = json.Marshal(Vars{"v": tc.val})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var vars Vars
+		if err := json.Unmarshal(data, &vars); err != nil {
+			t.Fatalf("%s: %s: %v", tc.name, data, err)
+		}
+		if !sameExact(vars["v"], tc.val) {
+			t.Errorf("%s: vars JSON %s: got %v, want %v", tc.name, data, vars["v"], tc.val)
+		}
+	}
+}
+
+// sameExact reports whether a and b have the same type and value, and floats
+// the same precision.
+func sameExact(a, b value.Value) bool {
+	switch a := a.(type) {
+	case value.Int:
+		b, ok := b.(value.Int)
+		return ok && a == b
+	case value.BigInt:
+		b, ok := b.(value.BigInt)
+		return ok && a.Int.Cmp(b.Int) == 0
+	case value.BigRat:
+		b, ok := b.(value.BigRat)
+		return ok && a.Rat.Cmp(b.Rat) == 0
+	case value.BigFloat:
+		b, ok := b.(value.BigFloat)
+		return ok && a.Float.Prec() == b.Float.Prec() && a.Float.Cmp(b.Float) == 0
+	case value.Complex:
+		if _, ok := b.(value.Complex); !ok {
+			return false
+		}
+		e := &eval{ctx: defaultCtx}
+		aRe, aIm := e.parts(a)
+		bRe, bIm := e.parts(b)
+		return e.err == nil && sameExact(aRe, bRe) && sameExact(aIm, bIm)
+	}
+	return false
+}
@@ -1,9 +1,10 @@
 package clac
 
-import "robpike.io/ivy/value"
+import (
+	"math/big"
+	"strconv"
 
-const (
-	variadic = -1
+	"robpike.io/ivy/value"
 )
 
 // x and y are the first and secod stack values, respectively
@@ -13,6 +14,7 @@ func (c *Clac) Undo() error {
 	if !c.hist.undo() {
 		return ErrNoMoreChanges
 	}
+	c.evKind = EventUndo
 	return ErrNoHistUpdate
 }
 
@@ -21,14 +23,58 @@ func (c *Clac) Redo() error {
 	if !c.hist.redo() {
 		return ErrNoMoreChanges
 	}
+	c.evKind = EventRedo
 	return ErrNoHistUpdate
 }
 
+// Goto makes the state in the undo history with the id given by the argument
+// current.  The id is an argument rather than a stack value, so going to a
+// state doesn't first add a state pushing the id.
+func (c *Clac) Goto(arg string) error {
+	id, err := parseIndex(arg)
+	if err != nil {
+		return err
+	}
+	return c.jump(id)
+}
+
+// Branch makes the tip of the undo history branch given by the argument
+// current.  Branches are numbered from 0 in order of creation.
+func (c *Clac) Branch(arg string) error {
+	n, err := parseIndex(arg)
+	if err != nil {
+		return err
+	}
+	ids := tips(c.hist.states)
+	if n >= len(ids) {
+		return ErrInvalidArg
+	}
+	return c.jump(ids[n])
+}
+
+func (c *Clac) jump(id int) error {
+	if !c.hist.jump(id) {
+		return ErrInvalidArg
+	}
+	c.evKind = EventJump
+	return ErrNoHistUpdate
+}
+
+// parseIndex parses a non-negative integer command argument.
+func parseIndex(arg string) (int, error) {
+	n, err := strconv.ParseUint(arg, 0, 31)
+	if err != nil {
+		return 0, ErrInvalidArg
+	}
+	return int(n), nil
+}
+
 // Clear clears the stack.
 func (c *Clac) Clear() error {
 	if len(c.working) == 0 {
 		return ErrNoHistUpdate
 	}
+	c.removed = append(c.removed, c.working...)
 	c.working = Stack{}
 	return nil
 }
@@ -141,9 +187,18 @@ func (c *Clac) Swap() error {
 	return c.rotate(1, 1, true)
 }
 
+// LastX pushes the values removed by the last command that removed any, which
+// are usually its arguments.
+func (c *Clac) LastX() error {
+	if len(c.lastX) == 0 {
+		return ErrNoHistUpdate
+	}
+	return c.insert(append(Stack{}, c.lastX...), 0)
+}
+
 // Depth returns the number of stack values
 func (c *Clac) Depth() error {
-	return c.Push(value.Int(len(c.Stack())))
+	return c.Push(value.Int(len(c.working)))
 }
 
 type floatFunc func(vals []value.Value) (value.Value, error)
@@ -197,7 +252,7 @@ func (c *Clac) applyInt(arity int, f intFunc) error {
 	}
 	ivals := make([]value.Value, arity)
 	for i, v := range vals {
-		ivals[i], err = Trunc(v)
+		ivals[i], err = c.trunc(v)
 		if err != nil {
 			return err
 		}
@@ -224,213 +279,250 @@ func reduceInt(initVal value.Value, vals []value.Value, f binIntFunc) (value.Val
 // Neg returns the negation of x.
 func (c *Clac) Neg() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("-", vals[0])
+		return c.unary("-", vals[0])
 	})
 }
 
-// Abs returns the absolute value of x.
+// Abs returns the absolute value, or magnitude, of x.
 func (c *Clac) Abs() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("abs", vals[0])
+		e := c.newEval()
+		val := e.cabs(vals[0])
+		return val, e.err
 	})
 }
 
 // Inv returns the inverse of x.
 func (c *Clac) Inv() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("/", vals[0])
+		return c.unary("/", vals[0])
 	})
 }
 
 // Add returns the sum of y and x.
 func (c *Clac) Add() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "+", vals[0])
+		return c.binary(vals[1], "+", vals[0])
 	})
 }
 
 // Sub returns the difference of y and x.
 func (c *Clac) Sub() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "-", vals[0])
+		return c.binary(vals[1], "-", vals[0])
 	})
 }
 
 // Mul returns the product of y and x.
 func (c *Clac) Mul() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "*", vals[0])
+		return c.binary(vals[1], "*", vals[0])
 	})
 }
 
 // Div returns the quotient of y divided by x.
 func (c *Clac) Div() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "/", vals[0])
+		return c.binary(vals[1], "/", vals[0])
 	})
 }
 
 // IntDiv returns the quotient of y divided by x.
 func (c *Clac) IntDiv() error {
 	return c.applyInt(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "div", vals[0])
+		return c.binary(vals[1], "div", vals[0])
 	})
 }
 
 // Mod returns the remainder of y divided by x.
 func (c *Clac) Mod() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "mod", vals[0])
+		return c.binary(vals[1], "mod", vals[0])
 	})
 }
 
 // Pow returns y to the x power.
 func (c *Clac) Pow() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "**", vals[0])
+		e := c.newEval()
+		val := e.cpow(vals[1], vals[0])
+		return val, e.err
 	})
 }
 
 // Sqrt returns the square root of x.
 func (c *Clac) Sqrt() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("sqrt", vals[0])
+		e := c.newEval()
+		val := e.csqrt(vals[0])
+		return val, e.err
 	})
 }
 
 // Exp returns e to the power of x.
 func (c *Clac) Exp() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("**", vals[0])
+		e := c.newEval()
+		val := e.cexp(vals[0])
+		return val, e.err
 	})
 }
 
 // Pow2 returns 2 to the power of x.
 func (c *Clac) Pow2() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return binary(value.Int(2), "**", vals[0])
+		e := c.newEval()
+		val := e.cpow(value.Int(2), vals[0])
+		return val, e.err
 	})
 }
 
 // Pow10 returns 10 to the power of x.
 func (c *Clac) Pow10() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return binary(value.Int(10), "**", vals[0])
+		e := c.newEval()
+		val := e.cpow(value.Int(10), vals[0])
+		return val, e.err
 	})
 }
 
 // LogN returns the base x log of y.
 func (c *Clac) LogN() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[0], "log", vals[1])
+		e := c.newEval()
+		val := e.clog(vals[0], vals[1])
+		return val, e.err
 	})
 }
 
 // Ln returns the natural log of x.
 func (c *Clac) Ln() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("log", vals[0])
+		e := c.newEval()
+		val := e.cln(vals[0])
+		return val, e.err
 	})
 }
 
 // Lg returns the base 2 logarithm of x.
 func (c *Clac) Lg() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return binary(value.Int(2), "log", vals[0])
+		e := c.newEval()
+		val := e.clog(value.Int(2), vals[0])
+		return val, e.err
 	})
 }
 
 // Log returns the base 10 logarithm of x.
 func (c *Clac) Log() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return binary(value.Int(10), "log", vals[0])
+		e := c.newEval()
+		val := e.clog(value.Int(10), vals[0])
+		return val, e.err
 	})
 }
 
 // Sin returns the sine of x.
 func (c *Clac) Sin() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("sin", vals[0])
+		e := c.newEval()
+		val := e.csin(vals[0])
+		return val, e.err
 	})
 }
 
 // Cos returns the cosine of x.
 func (c *Clac) Cos() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("cos", vals[0])
+		e := c.newEval()
+		val := e.ccos(vals[0])
+		return val, e.err
 	})
 }
 
 // Tan returns the tangent of x.
 func (c *Clac) Tan() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("tan", vals[0])
+		e := c.newEval()
+		val := e.ctan(vals[0])
+		return val, e.err
 	})
 }
 
 // Asin returns the arcsine of x.
 func (c *Clac) Asin() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("asin", vals[0])
+		e := c.newEval()
+		val := e.casin(vals[0])
+		return val, e.err
 	})
 }
 
 // Acos returns the arccosine of x.
 func (c *Clac) Acos() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("acos", vals[0])
+		e := c.newEval()
+		val := e.cacos(vals[0])
+		return val, e.err
 	})
 }
 
 // Atan returns the arctangent of x.
 func (c *Clac) Atan() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("atan", vals[0])
+		e := c.newEval()
+		val := e.catan(vals[0])
+		return val, e.err
 	})
 }
 
 // Atan2 returns the arctangent of y / x
 func (c *Clac) Atan2() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return atan2(vals[1], vals[0])
+		return c.atan2(vals[1], vals[0])
 	})
 }
 
-func atan2(x, y value.Value) (value.Value, error) {
-	e := &eval{}
+func (c *Clac) atan2(x, y value.Value) (value.Value, error) {
+	e := c.newEval()
+	angle := e.atan2(x, y)
+	return angle, e.err
+}
+
+func (e *eval) atan2(x, y value.Value) value.Value {
+	pi := e.pi()
 
 	// special cases
 	tan := zero
 	if isTrue(e.binary(y, "==", zero)) {
 		if isTrue(e.binary(x, "<", zero)) {
-			tan = Pi
+			tan = pi
 		}
-		return tan, e.err
+		return tan
 	}
 	if isTrue(e.binary(x, "==", zero)) {
 		ySgn := e.unary("sgn", y)
-		tan = e.binary(Pi, "/", value.Int(2))
-		tan = e.binary(tan, "*", ySgn)
-		return tan, e.err
+		tan = e.binary(pi, "/", value.Int(2))
+		return e.binary(tan, "*", ySgn)
 	}
 
 	tan = e.binary(y, "/", x)
 	angle := e.unary("atan", tan)
 	if isTrue(e.binary(x, "<", zero)) {
 		if isTrue(e.binary(tan, "<=", zero)) {
-			angle = e.binary(angle, "+", Pi)
+			angle = e.binary(angle, "+", pi)
 		} else {
-			angle = e.binary(angle, "-", Pi)
+			angle = e.binary(angle, "-", pi)
 		}
 	}
-	return angle, e.err
+	return angle
 }
 
 // DegToRad converts a value in degrees to radians.
 func (c *Clac) DegToRad() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		e := &eval{}
-		radPerDeg := e.binary(Pi, "/", value.Int(180))
+		e := c.newEval()
+		radPerDeg := e.binary(c.pi, "/", value.Int(180))
 		rad := e.binary(vals[0], "*", radPerDeg)
 		return rad, e.err
 	})
@@ -439,8 +531,8 @@ func (c *Clac) DegToRad() error {
 // RadToDeg converts a value in radians to degrees.
 func (c *Clac) RadToDeg() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		e := &eval{}
-		degPerRad := e.binary(value.Int(180), "/", Pi)
+		e := c.newEval()
+		degPerRad := e.binary(value.Int(180), "/", c.pi)
 		deg := e.binary(vals[0], "*", degPerRad)
 		return deg, e.err
 	})
@@ -449,31 +541,35 @@ func (c *Clac) RadToDeg() error {
 // Hypot calculates the 2D hypotenuse of a right triangles with legs x and y
 func (c *Clac) Hypot() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return hypot(vals[1], vals[0])
+		return c.hypot(vals[1], vals[0])
 	})
 }
 
-func hypot(x, y value.Value) (value.Value, error) {
-	e := &eval{}
-	hyp := e.unary("sqrt", e.binary(e.binary(x, "*", x), "+", e.binary(y, "*", y)))
+func (c *Clac) hypot(x, y value.Value) (value.Value, error) {
+	e := c.newEval()
+	hyp := e.hypot(x, y)
 	return hyp, e.err
 }
 
+func (e *eval) hypot(x, y value.Value) value.Value {
+	return e.unary("sqrt", e.binary(e.binary(x, "*", x), "+", e.binary(y, "*", y)))
+}
+
 // RectToPolar converts 2D rectangular coordinates y,x to polar coordinates.
 func (c *Clac) RectToPolar() error {
-	e := &eval{}
+	e := c.newEval()
 	y := e.e(func() (value.Value, error) { return c.Pop() })
 	x := e.e(func() (value.Value, error) { return c.Pop() })
-	radius := e.e(func() (value.Value, error) { return hypot(x, y) })
+	radius := e.e(func() (value.Value, error) { return c.hypot(x, y) })
 	e.e(func() (value.Value, error) { return zero, c.Push(radius) })
-	angle := e.e(func() (value.Value, error) { return atan2(x, y) })
+	angle := e.e(func() (value.Value, error) { return c.atan2(x, y) })
 	e.e(func() (value.Value, error) { return zero, c.Push(angle) })
 	return e.err
 }
 
 // PolarToRect converts 2D polar coordinates y<x to rectangular coordinates.
 func (c *Clac) PolarToRect() error {
-	e := &eval{}
+	e := c.newEval()
 	angle := e.e(func() (value.Value, error) { return c.Pop() })
 	radius := e.e(func() (value.Value, error) { return c.Pop() })
 	x := e.binary(radius, "*", e.unary("cos", angle))
@@ -486,126 +582,192 @@ func (c *Clac) PolarToRect() error {
 // Floor returns largest integer not greater than x.
 func (c *Clac) Floor() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("floor", vals[0])
+		return c.unary("floor", vals[0])
 	})
 }
 
 // Ceil returns smallest integer not less than x.
 func (c *Clac) Ceil() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return unary("ceil", vals[0])
+		return c.unary("ceil", vals[0])
 	})
 }
 
 // Trunc returns x truncated to the nearest integer toward 0.
 func (c *Clac) Trunc() error {
 	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
-		return Trunc(vals[0])
+		return c.trunc(vals[0])
 	})
 }
 
 // And returns the bitwise and of the integer portions of y and x.
 func (c *Clac) And() error {
 	return c.applyInt(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "&", vals[0])
+		return c.binary(vals[1], "&", vals[0])
 	})
 }
 
 // Or returns the bitwise or of the integer portions of y and x.
 func (c *Clac) Or() error {
 	return c.applyInt(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "|", vals[0])
+		return c.binary(vals[1], "|", vals[0])
 	})
 }
 
 // Xor returns the bitwise exclusive or of the integer portions of y and x.
 func (c *Clac) Xor() error {
 	return c.applyInt(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "^", vals[0])
+		return c.binary(vals[1], "^", vals[0])
 	})
 }
 
 // Not returns the bitwise not of the integer portion x.
 func (c *Clac) Not() error {
 	return c.applyInt(1, func(vals []value.Value) (value.Value, error) {
-		return unary("^", vals[0])
+		return c.unary("^", vals[0])
 	})
 }
 
 // AndN returns the bitwise and of the integer portions of the last x stack values.
 func (c *Clac) AndN() error {
-	return c.applyInt(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyInt(Variadic, func(vals []value.Value) (value.Value, error) {
 		return reduceInt(value.Int(-1), vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "&", b)
+			return c.binary(a, "&", b)
 		})
 	})
 }
 
 // OrN returns the bitwise or of the integer portions of the last x stack values.
 func (c *Clac) OrN() error {
-	return c.applyInt(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyInt(Variadic, func(vals []value.Value) (value.Value, error) {
 		return reduceInt(zero, vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "|", b)
+			return c.binary(a, "|", b)
 		})
 	})
 }
 
 // XorN returns the bitwise exclusive or of the integer portions of the last x stack values.
 func (c *Clac) XorN() error {
-	return c.applyInt(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyInt(Variadic, func(vals []value.Value) (value.Value, error) {
 		return reduceInt(zero, vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "^", b)
+			return c.binary(a, "^", b)
 		})
 	})
 }
 
+// Lt returns 1 if y is less than x, and 0 otherwise.
+func (c *Clac) Lt() error {
+	return c.compare("<")
+}
+
+// Le returns 1 if y is less than or equal to x, and 0 otherwise.
+func (c *Clac) Le() error {
+	return c.compare("<=")
+}
+
+// Eq returns 1 if y is equal to x, and 0 otherwise.
+func (c *Clac) Eq() error {
+	return c.compare("==")
+}
+
+// Ne returns 1 if y is not equal to x, and 0 otherwise.
+func (c *Clac) Ne() error {
+	return c.compare("!=")
+}
+
+// Ge returns 1 if y is greater than or equal to x, and 0 otherwise.
+func (c *Clac) Ge() error {
+	return c.compare(">=")
+}
+
+// Gt returns 1 if y is greater than x, and 0 otherwise.
+func (c *Clac) Gt() error {
+	return c.compare(">")
+}
+
+func (c *Clac) compare(op string) error {
+	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
+		return c.binary(vals[1], op, vals[0])
+	})
+}
+
+// ApproxEq returns 1 if z and y differ by at most x, and 0 otherwise.
+func (c *Clac) ApproxEq() error {
+	return c.applyFloat(3, func(vals []value.Value) (value.Value, error) {
+		e := c.newEval()
+		diff := e.unary("abs", e.binary(vals[2], "-", vals[1]))
+		return e.binary(diff, "<=", vals[0]), e.err
+	})
+}
+
+// LogicalAnd returns 1 if y and x are both non-zero, and 0 otherwise.
+func (c *Clac) LogicalAnd() error {
+	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
+		return c.binary(vals[1], "and", vals[0])
+	})
+}
+
+// LogicalOr returns 1 if y or x is non-zero, and 0 otherwise.
+func (c *Clac) LogicalOr() error {
+	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
+		return c.binary(vals[1], "or", vals[0])
+	})
+}
+
+// LogicalNot returns 1 if x is zero, and 0 otherwise.
+func (c *Clac) LogicalNot() error {
+	return c.applyFloat(1, func(vals []value.Value) (value.Value, error) {
+		return c.unary("not", vals[0])
+	})
+}
+
 // Sum returns the sum of the last x stack values
 func (c *Clac) Sum() error {
-	return c.applyFloat(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyFloat(Variadic, func(vals []value.Value) (value.Value, error) {
 		return reduceFloat(zero, vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "+", b)
+			return c.binary(a, "+", b)
 		})
 	})
 }
 
 // Avg returns the mean of the last x stack values
 func (c *Clac) Avg() error {
-	return c.applyFloat(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyFloat(Variadic, func(vals []value.Value) (value.Value, error) {
 		sum, _ := reduceFloat(zero, vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "+", b)
+			return c.binary(a, "+", b)
 		})
-		return binary(sum, "/", value.Int(len(vals)))
+		return c.binary(sum, "/", value.Int(len(vals)))
 	})
 }
 
 // Min returns the minimum of x and y
 func (c *Clac) Min() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "min", vals[0])
+		return c.binary(vals[1], "min", vals[0])
 	})
 }
 
 // Max returns the maximum of x and y
 func (c *Clac) Max() error {
 	return c.applyFloat(2, func(vals []value.Value) (value.Value, error) {
-		return binary(vals[1], "max", vals[0])
+		return c.binary(vals[1], "max", vals[0])
 	})
 }
 
 // MinN returns the minimum of the last x stack values.
 func (c *Clac) MinN() error {
-	return c.applyFloat(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyFloat(Variadic, func(vals []value.Value) (value.Value, error) {
 		return reduceFloat(vals[0], vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "min", b)
+			return c.binary(a, "min", b)
 		})
 	})
 }
 
 // MaxN returns the maximum of the last x stack values.
 func (c *Clac) MaxN() error {
-	return c.applyFloat(variadic, func(vals []value.Value) (value.Value, error) {
+	return c.applyFloat(Variadic, func(vals []value.Value) (value.Value, error) {
 		return reduceFloat(vals[0], vals, func(a, b value.Value) (value.Value, error) {
-			return binary(a, "max", b)
+			return c.binary(a, "max", b)
 		})
 	})
 }
@@ -613,49 +775,100 @@ func (c *Clac) MaxN() error {
 // Factorial returns the factorial of x
 func (c *Clac) Factorial() error {
 	return c.applyInt(1, func(vals []value.Value) (value.Value, error) {
-		return factorial(vals[0])
+		n, err := c.valToInt(vals[0])
+		if err != nil {
+			return zero, err
+		}
+		return c.product(2, n)
 	})
 }
 
-func factorial(val value.Value) (value.Value, error) {
-	e := eval{}
-	n, err := valToInt(val)
-	if err != nil {
-		return zero, err
-	}
-	var fact value.Value = value.Int(1)
-	for i := 2; i <= n; i++ {
-		fact = e.binary(fact, "*", value.Int(i))
-	}
-	return fact, e.err
-}
-
 // Comb returns the number of combinations of x taken from y
 func (c *Clac) Comb() error {
 	return c.applyInt(2, func(vals []value.Value) (value.Value, error) {
-		e := &eval{}
-		nf := e.e(func() (value.Value, error) { return factorial(vals[1]) })
-		rf := e.e(func() (value.Value, error) { return factorial(vals[0]) })
-		nr := e.binary(vals[1], "-", vals[0])
-		nrf := e.e(func() (value.Value, error) { return factorial(nr) })
-		denom := e.binary(nrf, "*", rf)
-		n := e.binary(nf, "/", denom)
-		return n, e.err
+		n, k, err := c.combArgs(vals)
+		if err != nil || k < 0 {
+			return zero, err
+		}
+		if n-k < k {
+			k = n - k
+		}
+		num, err := c.product(n-k+1, n)
+		if err != nil {
+			return zero, err
+		}
+		denom, err := c.product(2, k)
+		if err != nil {
+			return zero, err
+		}
+		return c.binary(num, "div", denom)
 	})
 }
 
 // Perm returns the number of permutations of x taken from y
 func (c *Clac) Perm() error {
 	return c.applyInt(2, func(vals []value.Value) (value.Value, error) {
-		e := &eval{}
-		nf := e.e(func() (value.Value, error) { return factorial(vals[1]) })
-		nr := e.binary(vals[1], "-", vals[0])
-		nrf := e.e(func() (value.Value, error) { return factorial(nr) })
-		n := e.binary(nf, "/", nrf)
-		return n, e.err
+		n, k, err := c.combArgs(vals)
+		if err != nil || k < 0 {
+			return zero, err
+		}
+		return c.product(n-k+1, n)
 	})
 }
 
+// combArgs returns the y and x arguments of Comb and Perm.  If x is not
+// between 0 and y, the result is 0, so k is returned as -1.
+func (c *Clac) combArgs(vals []value.Value) (n, k int, err error) {
+	if n, err = c.valToInt(vals[1]); err != nil {
+		return 0, 0, err
+	}
+	if k, err = c.valToInt(vals[0]); err != nil {
+		return 0, 0, err
+	}
+	if k < 0 || k > n {
+		return n, -1, nil
+	}
+	return n, k, nil
+}
+
+// product returns the product of the integers from a to b, or 1 if a > b.
+func (c *Clac) product(a, b int) (value.Value, error) {
+	p, err := c.bigProduct(int64(a), int64(b))
+	if err != nil {
+		return zero, err
+	}
+	return canonical(c.ctx, value.BigInt{Int: p})
+}
+
+// bigProduct returns the product of the integers from a to b, splitting the
+// range in half so the factors of each multiplication are of similar size,
+// which is much faster than multiplying in sequence.
+func (c *Clac) bigProduct(a, b int64) (*big.Int, error) {
+	if err := c.interrupted(); err != nil {
+		return nil, err
+	}
+	if b-a < 16 {
+		p := big.NewInt(1)
+		for i := a; i <= b; i++ {
+			p.Mul(p, big.NewInt(i))
+		}
+		return p, nil
+	}
+	m := a + (b-a)/2
+	lo, err := c.bigProduct(a, m)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := c.bigProduct(m+1, b)
+	if err != nil {
+		return nil, err
+	}
+	if c.maxBits > 0 && lo.BitLen()+hi.BitLen()-1 > c.maxBits {
+		return nil, ErrTooLarge
+	}
+	return lo.Mul(lo, hi), nil
+}
+
 // Dot returns the dot product of two vectors of size x
 // The vectors are composed of the 2*x items on the stack above x
 func (c *Clac) Dot() error {
@@ -673,7 +886,7 @@ func (c *Clac) Dot3() error {
 }
 
 func (c *Clac) dot(num int) error {
-	e := &eval{}
+	e := c.newEval()
 	vals, err := c.remove(0, 2*num)
 	if err != nil {
 		return err
@@ -692,7 +905,7 @@ func (c *Clac) dot(num int) error {
 // Cross returns the cross product of two 3D vectors
 // The vectors are composed of the last 6 items on the stack
 func (c *Clac) Cross() error {
-	e := &eval{}
+	e := c.newEval()
 	vals, err := c.remove(0, 6)
 	if err != nil {
 		return err
@@ -711,13 +924,13 @@ func (c *Clac) Cross() error {
 
 // Mag returns the magnitude of the vector represented by the last x stack values
 func (c *Clac) Mag() error {
-	return c.applyFloat(variadic, func(vals []value.Value) (value.Value, error) {
-		e := &eval{}
+	return c.applyFloat(Variadic, func(vals []value.Value) (value.Value, error) {
+		e := c.newEval()
 		magSq, _ := reduceFloat(zero, vals, func(a, b value.Value) (value.Value, error) {
 			mag := e.binary(a, "+", e.binary(b, "*", b))
 			return mag, e.err
 		})
-		return unary("sqrt", magSq)
+		return c.unary("sqrt", magSq)
 	})
 }
 
@@ -0,0 +1,82 @@
+package clac
+
+import "testing"
+
+func TestCombinatorics(t *testing.T) {
+	for _, tc := range []struct {
+		input, want string
+	}{
+		{"0 !", "1"},
+		{"1 !", "1"},
+		{"-3 !", "1"},
+		{"15 !", "1307674368000"},
+		{"20 !", "2432902008176640000"},
+		{"30 !", "265252859812191058636308480000000"},
+		{"5 0 comb", "1"},
+		{"5 5 comb", "1"},
+		{"5 6 comb", "0"},
+		{"5 -1 comb", "0"},
+		{"-5 2 comb", "0"},
+		{"52 5 comb", "2598960"},
+		{"60 30 comb", "118264581564861424"},
+		{"1000000 3 comb", "166666166667000000"},
+		{"5 0 perm", "1"},
+		{"5 5 perm", "120"},
+		{"5 6 perm", "0"},
+		{"5 -1 perm", "0"},
+		{"-5 2 perm", "0"},
+		{"30 20 perm", "73096577329197271449600000"},
+		{"1000000 3 perm", "999997000002000000"},
+	} {
+		c := New()
+		stack, err := c.Run(tc.input)
+		if err != nil {
+			t.Errorf("%s: %v", tc.input, err)
+			continue
+		}
+		if got := stackText(t, stack); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestLastX(t *testing.T) {
+	for _, tc := range []struct{ input, want string }{
+		{"3 4 + lastx", "7 3 4"},
+		{"1 2 3 3 sum 9 lastx", "6 9 1 2 3 3"},
+		{"3 0 + lastx", "3 3 0"},
+		{"5 1 * lastx", "5 5 1"},
+		{"1 2 swap lastx", "2 1"},
+		{"1 2 clear lastx", "1 2"},
+		{"1 2 + 5 lastx", "3 5 1 2"},
+	} {
+		for _, atomic := range []bool{false, true} {
+			c := New()
+			var s Stack
+			var err error
+			if atomic {
+				s, err = c.RunAtomic(tc.input)
+			} else {
+				s, err = c.Run(tc.input)
+			}
+			if got := stackText(t, s); err != nil || got != tc.want {
+				t.Errorf("%s (atomic %v): got %q %v, want %q", tc.input, atomic, got, err, tc.want)
+			}
+		}
+	}
+	c := New()
+	s, err := c.Run(": f + ; 3 0 f lastx")
+	if got := stackText(t, s); err != nil || got != "3 3 0" {
+		t.Errorf("word: got %q %v", got, err)
+	}
+	s, err = c.Run("clear 1 [ 3 0 + ] if lastx")
+	if got := stackText(t, s); err != nil || got != "3 3 0" {
+		t.Errorf("block: got %q %v", got, err)
+	}
+	c.Run("clear 7 8 *")
+	c.RunAtomic("1 2 + foo")
+	s, _ = c.Run("lastx")
+	if got := stackText(t, s); got != "56 7 8" {
+		t.Errorf("failed atomic: got %q", got)
+	}
+}
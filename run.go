@@ -0,0 +1,400 @@
+package clac
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// OpError records a failed operation, and where it occurred in the input.
+type OpError struct {
+	Op    string // command name, or "push" for numbers
+	Index int    // index of the token in the input
+	Line  int    // line of the token in the input, starting at 1
+	Col   int    // column of the token in its line, starting at 1
+	Depth int    // stack depth when the operation was attempted
+	Err   error  // underlying error
+}
+
+func (e *OpError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+type token struct {
+	text  string
+	index int
+	line  int
+	col   int
+}
+
+// lex splits input into whitespace separated tokens.  A token starting with #
+// starts a comment, which continues to the end of the line.  A token starting
+// with " is a quoted string, which continues to the next ", and may contain
+// whitespace.
+func lex(input string) []token {
+	var toks []token
+	line, col := 1, 1
+	start := -1
+	inComment, inString := false, false
+	var tok token
+	for i, r := range input {
+		if inComment {
+			inComment = r != '\n'
+		} else if start < 0 && r == '#' {
+			inComment = true
+		} else if start < 0 && r == '"' {
+			start, inString = i, true
+			tok = token{index: len(toks), line: line, col: col}
+		} else if inString {
+			if r == '"' {
+				tok.text = input[start : i+1]
+				toks = append(toks, tok)
+				start, inString = -1, false
+			}
+		} else if unicode.IsSpace(r) {
+			if start >= 0 {
+				tok.text = input[start:i]
+				toks = append(toks, tok)
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+			tok = token{index: len(toks), line: line, col: col}
+		}
+		col++
+		if r == '\n' {
+			line++
+			col = 1
+		}
+	}
+	if start >= 0 {
+		tok.text = input[start:]
+		toks = append(toks, tok)
+	}
+	return toks
+}
+
+// Run executes RPN input, consisting of whitespace separated numbers, command
+// names, variable names, and comments, and returns the resulting stack.  A
+// variable name pushes the variable's value, and a variable name prefixed by
+// = pops x and stores it in the variable.  A quoted string following a
+// command is a message for the command, used by assertions, and a quoted
+// string may be used as the argument of a command that takes one.
+//
+// A word definition, ": name ... ;", defines a command named name that
+// executes the input between name and ;, as for RunAtomic.  A control
+// construct, such as "[ neg ] if", executes blocks enclosed in brackets.  Its
+// forms are:
+//
+//	[ a ] if             pops x and executes a if x is not 0
+//	[ a ] [ b ] ifelse   pops x and executes a if x is not 0, or else b
+//	[ a ] times          pops x and executes a x times
+//	[ a ] [ b ] while    executes a and pops x, then if x is not 0,
+//	                     executes b and repeats
+//
+// Each item of input, such as a command and its argument, a word definition,
+// or a control construct, is executed via Exec, so it may be undone
+// individually.  Execution stops at the first error, which is returned as an
+// *OpError.  An error in a block is reported for the failing item in the
+// block, and an error in a word for the word.
+func (c *Clac) Run(input string) (Stack, error) {
+	return c.RunContext(context.Background(), input)
+}
+
+// RunContext is like Run, but executes each command via ExecContext, so
+// execution stops with the context's error once the context is done.
+func (c *Clac) RunContext(ctx context.Context, input string) (Stack, error) {
+	toks := lex(input)
+	for len(toks) > 0 {
+		st, err := c.parseStep(toks)
+		if err == nil {
+			err = c.ExecCmdContext(ctx, st.name, func() error {
+				c.depth++
+				defer func() { c.depth-- }()
+				return st.f()
+			})
+		}
+		if err != nil {
+			err = c.opError(st, len(c.Stack()), err)
+		}
+		c.mu.Lock()
+		onTrace := c.onTrace
+		c.mu.Unlock()
+		if onTrace != nil {
+			onTrace(Trace{Item: joinToks(toks[:st.n]), Stack: c.Stack(), Err: err})
+		}
+		if err != nil {
+			return c.Stack(), err
+		}
+		toks = toks[st.n:]
+	}
+	return c.Stack(), nil
+}
+
+// RunAtomic is like Run, but executes input as a single command, so its
+// changes are recorded as one step in the undo history.  If any command
+// fails, the stack and undo history are left unchanged.  Commands that move
+// to another state in the undo history, such as undo, discard the changes
+// made by the commands before them.
+func (c *Clac) RunAtomic(input string) (Stack, error) {
+	return c.RunAtomicContext(context.Background(), input)
+}
+
+// RunAtomicContext is like RunAtomic, but executes input via ExecContext.
+func (c *Clac) RunAtomicContext(ctx context.Context, input string) (Stack, error) {
+	toks := lex(input)
+	err := c.ExecCmdContext(ctx, joinToks(toks), func() error { return c.runAtomic(toks) })
+	return c.Stack(), err
+}
+
+// maxNest limits the nesting of atomic runs, such as words calling words.
+const maxNest = 1000
+
+// runAtomic executes toks as a single command.
+func (c *Clac) runAtomic(toks []token) error {
+	return c.atomic(func() (bool, error) { return c.runSteps(toks) })
+}
+
+// atomic executes f as a single command.  f reports whether it changed the
+// working stack or variables, as for runSteps.  Only the outermost of nested
+// calls saves the history to restore on failure.
+func (c *Clac) atomic(f func() (bool, error)) error {
+	if c.nest >= maxNest {
+		return ErrTooDeep
+	}
+	var saved *stackHist
+	var savedLastX Stack
+	if c.nest == 0 {
+		saved, savedLastX = c.hist.clone(), c.lastX
+	}
+	c.nest++
+	changed, err := f()
+	c.nest--
+	if err != nil {
+		if saved != nil {
+			c.hist, c.lastX = saved, savedLastX
+		}
+		return err
+	}
+	if !changed {
+		return ErrNoHistUpdate
+	}
+	c.evKind = EventExec
+	return nil
+}
+
+// runSteps executes toks, and reports whether the working stack or variables
+// were changed since the last move to another state in the undo history.
+func (c *Clac) runSteps(toks []token) (bool, error) {
+	c.depth++
+	defer func() { c.depth-- }()
+	changed := false
+	for len(toks) > 0 {
+		st, err := c.parseStep(toks)
+		if err != nil {
+			err = c.opError(st, len(c.working), err)
+			c.trace(toks[:1], c.working, err)
+			return false, err
+		}
+		hist, cur := c.hist, c.hist.cur
+		depth := len(c.working)
+		prev, prevVars := append(Stack{}, c.working...), c.vars
+		c.removed = nil
+		switch err := st.f(); {
+		case err == nil:
+			c.keepRemoved()
+			changed = true
+		case err != ErrNoHistUpdate:
+			err = c.opError(st, depth, err)
+			c.trace(toks[:st.n], prev, err)
+			return false, err
+		case c.hist != hist || c.hist.cur != cur:
+			c.updateWorking()
+			c.removed, changed = nil, false
+		default:
+			c.working, c.vars, c.removed = prev, prevVars, nil
+		}
+		c.trace(toks[:st.n], c.working, nil)
+		toks = toks[st.n:]
+	}
+	return changed, nil
+}
+
+// Trace describes an item of input executed by Run or RunAtomic.
+type Trace struct {
+	Item  string // item of input, such as a command and its argument
+	Depth int    // depth of nesting within words and blocks, 0 at the top
+	Stack Stack  // resulting stack
+	Err   error  // error, if the item failed
+}
+
+// OnTrace sets f to be called after each item of input is executed by Run
+// or RunAtomic, including the items of words and blocks.  f is called while
+// nested items run, so it must not call methods of c other than Sprint and
+// SetFormat.  OnTrace must not be called from inside a command, such as the
+// Func of a registered Cmd, as it would deadlock.
+func (c *Clac) OnTrace(f func(Trace)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onTrace = f
+}
+
+// trace reports the execution of the item toks within runSteps.
+func (c *Clac) trace(toks []token, stack Stack, err error) {
+	if c.onTrace == nil {
+		return
+	}
+	c.onTrace(Trace{Item: joinToks(toks), Depth: c.depth - 1, Stack: append(Stack{}, stack...), Err: err})
+}
+
+// Split returns the items of input, such as commands and their arguments,
+// word definitions, and control constructs, as Run would execute them.
+func (c *Clac) Split(input string) []string {
+	var items []string
+	toks := lex(input)
+	for len(toks) > 0 {
+		n := 1
+		if st, err := c.parseStep(toks); err == nil {
+			n = st.n
+		}
+		items = append(items, joinToks(toks[:n]))
+		toks = toks[n:]
+	}
+	return items
+}
+
+// step is a number, variable, or command, and its argument if it takes one,
+// ready to be executed.
+type step struct {
+	tok  token        // first token
+	op   string       // operation name for errors
+	name string       // command name for the undo history
+	n    int          // number of tokens consumed
+	f    func() error // implementation
+}
+
+// parseStep parses the number, variable, command, word definition, or control
+// construct at the start of toks.
+func (c *Clac) parseStep(toks []token) (step, error) {
+	tok := toks[0]
+	st := step{tok: tok, op: tok.text, name: tok.text, n: 1}
+	if num, err := c.ParseNum(tok.text); err == nil {
+		st.op = "push"
+		st.f = func() error { return c.Push(num) }
+	} else if tok.text == ":" {
+		end := 1
+		for end < len(toks) && toks[end].text != ";" {
+			end++
+		}
+		if end < 2 || end == len(toks) {
+			return st, ErrMissingArg
+		}
+		name, body := toks[1].text, joinToks(toks[2:end])
+		st.name = joinToks(toks[:end+1])
+		st.n = end + 1
+		st.f = func() error {
+			if err := c.Define(name, body); err != nil {
+				return err
+			}
+			return ErrNoHistUpdate
+		}
+	} else if tok.text == "[" {
+		var blocks [][]token
+		n := 0
+		for n < len(toks) && toks[n].text == "[" {
+			block, m, err := parseBlock(toks[n:])
+			if err != nil {
+				return st, err
+			}
+			blocks = append(blocks, block)
+			n += m
+		}
+		if n == len(toks) {
+			return st, ErrMissingArg
+		}
+		st.op = toks[n].text
+		st.name = joinToks(toks[:n+1])
+		st.n = n + 1
+		f, err := c.control(st.op, blocks)
+		if err != nil {
+			return st, err
+		}
+		st.f = f
+	} else if _, ok := controls[tok.text]; ok {
+		return st, ErrMissingArg
+	} else if cmd, ok := c.lookup(tok.text); ok {
+		if cmd.ArgFunc == nil {
+			msg := ""
+			if len(toks) > 1 && isQuoted(toks[1].text) {
+				msg = unquote(toks[1].text)
+				st.name += " " + toks[1].text
+				st.n++
+			}
+			st.f = func() error {
+				c.msg, c.msgTok = msg, tok
+				err := cmd.Func(c)
+				c.msg = ""
+				return err
+			}
+			return st, nil
+		}
+		if len(toks) < 2 {
+			return st, ErrMissingArg
+		}
+		arg := unquote(toks[1].text)
+		st.name += " " + toks[1].text
+		st.n++
+		st.f = func() error { return cmd.ArgFunc(c, arg) }
+	} else if strings.HasPrefix(tok.text, "\"") {
+		return st, ErrInvalidInput
+	} else if strings.HasPrefix(tok.text, "=") {
+		name := tok.text[1:]
+		st.f = func() error { return c.Store(name) }
+	} else {
+		st.f = func() error { return c.Recall(tok.text) }
+	}
+	return st, nil
+}
+
+// opError returns err as an *OpError for st, unless it already is one, for a
+// step within a block of st.
+func (c *Clac) opError(st step, depth int, err error) *OpError {
+	if opErr, ok := err.(*OpError); ok {
+		return opErr
+	}
+	return &OpError{
+		Op:    st.op,
+		Index: st.tok.index,
+		Line:  st.tok.line,
+		Col:   st.tok.col,
+		Depth: depth,
+		Err:   err,
+	}
+}
+
+// isQuoted reports whether text is a quoted string.
+func isQuoted(text string) bool {
+	return len(text) >= 2 && strings.HasPrefix(text, "\"") && strings.HasSuffix(text, "\"")
+}
+
+// unquote returns text without its quotes, if it is a quoted string.
+func unquote(text string) string {
+	if isQuoted(text) {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
+
+// joinToks returns the text of toks separated by spaces.
+func joinToks(toks []token) string {
+	texts := make([]string, len(toks))
+	for i, tok := range toks {
+		texts[i] = tok.text
+	}
+	return strings.Join(texts, " ")
+}
@@ -0,0 +1,25 @@
+package clac
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpErrorNesting(t *testing.T) {
+	for _, tc := range []struct {
+		input     string
+		msg       string
+		line, col int
+	}{
+		{": f f ; 1 f", "f: nesting too deep", 1, 11},
+		{"1 [ 1 [ 0 0 / ] if ] if", "/: division by zero", 1, 13},
+		{": g 0 / ; 2\n  g", "g: division by zero", 2, 3},
+	} {
+		c := New()
+		_, err := c.Run(tc.input)
+		var opErr *OpError
+		if !errors.As(err, &opErr) || err.Error() != tc.msg || opErr.Line != tc.line || opErr.Col != tc.col {
+			t.Errorf("%q: got %v at %+v, want %s at %d:%d", tc.input, err, opErr, tc.msg, tc.line, tc.col)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package clac
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// session is the saved state of a Clac.
+type session struct {
+	Stack Stack             `json:"stack"`
+	Vars  Vars              `json:"vars,omitempty"`
+	Words map[string]string `json:"words,omitempty"`
+	Hist  []HistEntry       `json:"hist"`
+	Cur   int               `json:"cur"`
+}
+
+// SaveSession writes the stack, variables, user defined words, and undo
+// history to w as JSON.
+func (c *Clac) SaveSession(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveSession(w)
+}
+
+// LoadSession replaces the stack, variables, and undo history with a session
+// previously written by SaveSession, and defines its words.
+func (c *Clac) LoadSession(r io.Reader) error {
+	c.mu.Lock()
+	defer c.unlock()
+	return c.exec(context.Background(), "", func() error { return c.loadSession(r) })
+}
+
+func (c *Clac) saveSession(w io.Writer) error {
+	hist := c.hist
+	if c.nest > 0 {
+		// an atomic run, such as a script, saves the working state it has
+		// reached, which is not in the history until the run completes
+		hist = hist.clone()
+		if c.keepHist {
+			hist.push(c.working, c.vars, "save")
+		} else {
+			hist.replace(c.working, c.vars, "save")
+		}
+	}
+	sess := session{
+		Stack: hist.stack(),
+		Vars:  hist.vars(),
+		Words: c.Words(),
+		Hist:  hist.entries(),
+		Cur:   hist.states[hist.cur].id,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(sess)
+}
+
+func (c *Clac) loadSession(r io.Reader) error {
+	var sess session
+	if err := json.NewDecoder(r).Decode(&sess); err != nil {
+		return err
+	}
+	hist := newStackHist()
+	if c.keepHist {
+		var err error
+		if hist, err = newStackHistFrom(sess.Hist, sess.Cur); err != nil {
+			return err
+		}
+		hist.trim(c.maxSteps, c.maxBytes)
+	} else {
+		hist.replace(sess.Stack, sess.Vars, "load")
+	}
+	// check the words against the loaded variables, before defining any, so
+	// a failed load changes nothing; exec restores the working variables
+	c.vars = hist.vars()
+	c.cmdMu.RLock()
+	for name := range sess.Words {
+		if err := c.checkWord(name); err != nil {
+			c.cmdMu.RUnlock()
+			return err
+		}
+	}
+	c.cmdMu.RUnlock()
+	for name, body := range sess.Words {
+		if err := c.Define(name, body); err != nil {
+			return err
+		}
+	}
+	c.hist = hist
+	c.evKind = EventLoad
+	return ErrNoHistUpdate
+}
+
+// Save saves the session to the file named by the argument.
+func (c *Clac) Save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := c.saveSession(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return ErrNoHistUpdate
+}
+
+// Load loads the session or script from the file named by the argument.  A
+// file starting with { is a session written by Save, and any other file is a
+// script, which is executed as for RunAtomic.
+func (c *Clac) Load(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return c.loadSession(bytes.NewReader(data))
+	}
+	err = c.runAtomic(lex(string(data)))
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return fmt.Errorf("%s:%d:%d: %w", name, opErr.Line, opErr.Col, err)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package clac
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	c := New()
+	c.EnableHistory(true)
+	for _, input := range []string{"1 2 3", "+", "undo", "*", "=v", "4 5"} {
+		if _, err := c.Run(input); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := c.SaveSession(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New()
+	d.EnableHistory(true)
+	if err := d.LoadSession(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	wantHist, wantCur := c.History()
+	gotHist, gotCur := d.History()
+	if gotCur != wantCur || len(gotHist) != len(wantHist) {
+		t.Fatalf("history: got %d entries at %d, want %d at %d", len(gotHist), gotCur, len(wantHist), wantCur)
+	}
+	for i := range wantHist {
+		want, got := wantHist[i], gotHist[i]
+		if got.ID != want.ID || got.Parent != want.Parent || got.Cmd != want.Cmd ||
+			stackText(t, got.Stack) != stackText(t, want.Stack) ||
+			!reflect.DeepEqual(varStrs(t, got.Vars), varStrs(t, want.Vars)) {
+			t.Errorf("entry %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	// the loaded states share stack nodes with their parents
+	if d.hist.size != c.hist.size {
+		t.Errorf("history size: got %d, want %d", d.hist.size, c.hist.size)
+	}
+}
+
+func TestLoadSessionInvalid(t *testing.T) {
+	for _, sess := range []string{
+		`{"stack":[],"hist":[{"id":1,"parent":1,"stack":[]}],"cur":1}`,
+		`{"stack":[],"hist":[{"id":1,"parent":2,"stack":[]},{"id":2,"parent":1,"stack":[]}],"cur":1}`,
+		`{"stack":[],"hist":[{"id":1,"parent":-2,"stack":[]}],"cur":1}`,
+		`{"stack":[],"hist":[{"id":2,"parent":-1,"stack":[]},{"id":1,"parent":-1,"stack":[]}],"cur":1}`,
+		`{"stack":[],"hist":[{"id":0,"parent":-1,"stack":[]}],"cur":5}`,
+	} {
+		c := New()
+		c.EnableHistory(true)
+		if err := c.LoadSession(strings.NewReader(sess)); err != ErrInvalidSession {
+			t.Errorf("%s: got %v, want %v", sess, err, ErrInvalidSession)
+		}
+	}
+}
+
+func stackText(t *testing.T, stack Stack) string {
+	text, err := stack.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+func varStrs(t *testing.T, vars Vars) map[string]string {
+	strs := map[string]string{}
+	for name, val := range vars {
+		str, err := marshalVal(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		strs[name] = str
+	}
+	return strs
+}
+
+func TestLoadSessionInvalidKeepsWords(t *testing.T) {
+	c := New()
+	c.EnableHistory(true)
+	sess := `{"stack":[],"words":{"sq":"dup *"},"hist":[{"id":1,"parent":1,"stack":[]}],"cur":1}`
+	if err := c.LoadSession(strings.NewReader(sess)); err != ErrInvalidSession {
+		t.Fatalf("got %v, want %v", err, ErrInvalidSession)
+	}
+	if words := c.Words(); len(words) != 0 {
+		t.Errorf("words defined by failed load: %v", words)
+	}
+	sess = `{"stack":[],"words":{"sq":"dup *","+":"-"},"hist":[{"id":1,"parent":-1,"stack":[]}],"cur":1}`
+	if err := c.LoadSession(strings.NewReader(sess)); err != ErrNameInUse {
+		t.Fatalf("got %v, want %v", err, ErrNameInUse)
+	}
+	if words := c.Words(); len(words) != 0 {
+		t.Errorf("words defined by failed load: %v", words)
+	}
+}
+
+func TestSaveInAtomicRun(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sess")
+	c := New()
+	c.EnableHistory(true)
+	if _, err := c.Run("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RunAtomic("2 3 + dup =v save " + name + " drop"); err != nil {
+		t.Fatal(err)
+	}
+	d := New()
+	d.EnableHistory(true)
+	if _, err := d.Run("load " + name); err != nil {
+		t.Fatal(err)
+	}
+	if got := stackText(t, d.Stack()); got != "1 5" {
+		t.Errorf("stack: got %q, want %q", got, "1 5")
+	}
+	if got := varStrs(t, d.Vars()); !reflect.DeepEqual(got, map[string]string{"v": "5"}) {
+		t.Errorf("vars: got %v", got)
+	}
+}
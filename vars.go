@@ -0,0 +1,92 @@
+package clac
+
+import (
+	"strings"
+
+	"robpike.io/ivy/value"
+)
+
+// Vars maps variable names to values.
+type Vars map[string]value.Value
+
+func (v Vars) clone() Vars {
+	if v == nil {
+		return nil
+	}
+	vars := make(Vars, len(v))
+	for name, val := range v {
+		vars[name] = val
+	}
+	return vars
+}
+
+// Vars returns a copy of the variables as of the last completed command.
+func (c *Clac) Vars() Vars {
+	c.snapMu.RLock()
+	defer c.snapMu.RUnlock()
+	return c.snapHist[c.snapCur].vars.clone()
+}
+
+// SetVar sets the named variable to val, as for Store.
+func (c *Clac) SetVar(name string, val value.Value) error {
+	return c.ExecCmd("="+name, func() error {
+		if err := c.Push(val); err != nil {
+			return err
+		}
+		return c.Store(name)
+	})
+}
+
+// Store pops x and stores it in the named variable.  Names must not be
+// numbers or commands.
+func (c *Clac) Store(name string) error {
+	if err := c.checkVarName(name); err != nil {
+		return err
+	}
+	x, err := c.Pop()
+	if err != nil {
+		return err
+	}
+	vars := c.vars.clone()
+	if vars == nil {
+		vars = Vars{}
+	}
+	vars[name] = x
+	c.vars = vars
+	return nil
+}
+
+// Recall pushes the value of the named variable.
+func (c *Clac) Recall(name string) error {
+	val, ok := c.vars[name]
+	if !ok {
+		return ErrInvalidInput
+	}
+	return c.Push(val)
+}
+
+// Unset deletes the named variable.
+func (c *Clac) Unset(name string) error {
+	if _, ok := c.vars[name]; !ok {
+		return ErrInvalidArg
+	}
+	vars := c.vars.clone()
+	delete(vars, name)
+	c.vars = vars
+	return nil
+}
+
+// checkVarName returns ErrNameInUse if name is the name of a command, or
+// ErrInvalidArg if it may not otherwise be used as a variable name.
+func (c *Clac) checkVarName(name string) error {
+	if name == "" || isReserved(name) || strings.HasPrefix(name, "=") {
+		return ErrInvalidArg
+	}
+	if _, err := c.ParseNum(name); err == nil {
+		return ErrInvalidArg
+	}
+	if _, ok := c.lookup(name); ok {
+		return ErrNameInUse
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package clac
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVarNames(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		err   error
+	}{
+		{"5 =rate", nil},
+		{"5 =x", ErrNameInUse},
+		{"5 =e", ErrNameInUse},
+		{"5 =pi", ErrNameInUse},
+		{"5 =1", ErrInvalidArg},
+		{"5 =", ErrInvalidArg},
+		{": sq dup * ; 5 =sq", ErrNameInUse},
+		{"5 =sq : sq dup * ;", ErrNameInUse},
+		{"5 =sq drop 1 sq", nil},
+		{": + dup * ;", ErrNameInUse},
+		{": 2 dup * ;", ErrInvalidArg},
+	} {
+		c := New()
+		if _, err := c.Run(tc.input); !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, want %v", tc.input, err, tc.err)
+		}
+	}
+}
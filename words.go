@@ -0,0 +1,115 @@
+package clac
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Define defines a word, a command named name that executes body as for
+// RunAtomic.  Words may be redefined, but other commands and variables may
+// not.  Words are not part of the undo history.  Like the command methods,
+// Define must only be called via Exec when a Clac is shared between
+// goroutines.
+func (c *Clac) Define(name, body string) error {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	if err := c.checkWord(name); err != nil {
+		return err
+	}
+	toks := lex(body)
+	c.words[name] = joinToks(toks)
+	c.cmds[name] = Cmd{
+		Name:     name,
+		Arity:    Variadic,
+		Category: "user",
+		Help:     c.words[name],
+		Func:     func(c *Clac) error { return c.runWord(toks) },
+	}
+	return nil
+}
+
+// runWord executes the body of a word.  A failing step of the body is reported
+// as a failure of the word, at its position in the input using it.
+func (c *Clac) runWord(toks []token) error {
+	err := c.runAtomic(toks)
+	if opErr, ok := err.(*OpError); ok {
+		return opErr.Err
+	}
+	return err
+}
+
+// checkWord returns ErrNameInUse if name is the name of a variable or of a
+// command other than a word, or ErrInvalidArg if it may not otherwise be
+// defined as a word.  It must be called with c.cmdMu held.
+func (c *Clac) checkWord(name string) error {
+	if isReserved(name) || strings.HasPrefix(name, "=") {
+		return ErrInvalidArg
+	}
+	if _, err := c.ParseNum(name); err == nil {
+		return ErrInvalidArg
+	}
+	if _, ok := c.vars[name]; ok {
+		return ErrNameInUse
+	}
+	if _, ok := c.words[name]; !ok {
+		if _, ok := c.cmds[name]; ok {
+			return ErrNameInUse
+		}
+		if _, ok := cmdsByName[name]; ok {
+			return ErrNameInUse
+		}
+	}
+	return nil
+}
+
+// Words returns the bodies of the user defined words, by name.
+func (c *Clac) Words() map[string]string {
+	c.cmdMu.RLock()
+	defer c.cmdMu.RUnlock()
+	words := make(map[string]string, len(c.words))
+	for name, body := range c.words {
+		words[name] = body
+	}
+	return words
+}
+
+// Cmds returns the commands available to the instance, built-in commands
+// first, followed by registered commands and words in order of name.
+// Registered commands replace built-in commands of the same name.
+func (c *Clac) Cmds() []Cmd {
+	c.cmdMu.RLock()
+	defer c.cmdMu.RUnlock()
+	var all, reg []Cmd
+	for _, cmd := range cmds {
+		if _, ok := c.cmds[cmd.Name]; !ok {
+			all = append(all, cmd)
+		}
+	}
+	for name, cmd := range c.cmds {
+		if name == cmd.Name {
+			reg = append(reg, cmd)
+		}
+	}
+	sort.Slice(reg, func(i, j int) bool { return reg[i].Name < reg[j].Name })
+	return append(all, reg...)
+}
+
+// SaveWords saves the definitions of the user defined words to the file
+// named by the argument, in a form that may be run to define them again.
+func (c *Clac) SaveWords(name string) error {
+	words := c.Words()
+	names := make([]string, 0, len(words))
+	for name := range words {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var defs strings.Builder
+	for _, name := range names {
+		defs.WriteString(": " + name + " " + words[name] + " ;\n")
+	}
+	if err := ioutil.WriteFile(name, []byte(defs.String()), 0666); err != nil {
+		return err
+	}
+	return ErrNoHistUpdate
+}